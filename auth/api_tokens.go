@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"instagramplusbackend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// API token scopes, each scope includes the ones before it
+const (
+	ScopeRead  = "read"
+	ScopePost  = "post"
+	ScopeAdmin = "admin"
+)
+
+var scopeOrder = []string{ScopeRead, ScopePost, ScopeAdmin}
+
+// apiTokenPrefix makes tokens recognizable, e.g. for secret scanners
+const apiTokenPrefix = "ipt_"
+
+// apiTokenTouchInterval limits last-used updates to one write per interval
+const apiTokenTouchInterval = time.Minute
+
+var ErrAPITokenNotFound = errors.New("api token not found")
+
+var ErrAdminScopeNotAllowed = errors.New("only admins can create admin tokens")
+
+// HasScope reports whether the granted scopes include the required one
+func HasScope(granted []string, required string) bool {
+	requiredLevel := slices.Index(scopeOrder, required)
+	for _, scope := range granted {
+		if slices.Index(scopeOrder, scope) >= requiredLevel && requiredLevel >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateAPIToken issues a named token, the plain token is returned only here and stored hashed
+func (a *AuthModule) CreateAPIToken(ctx context.Context, userID int, name string, scopes []string) (models.APIToken, string, error) {
+	if slices.Contains(scopes, ScopeAdmin) {
+		var isAdmin bool
+		if err := a.db.QueryRow(ctx, "SELECT is_admin FROM users WHERE id = $1", userID).Scan(&isAdmin); err != nil {
+			return models.APIToken{}, "", err
+		}
+		if !isAdmin {
+			return models.APIToken{}, "", ErrAdminScopeNotAllowed
+		}
+	}
+
+	secret, err := generateSecureToken(32)
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+	token := apiTokenPrefix + strings.TrimRight(secret, "=")
+
+	apiToken := models.APIToken{Name: name, Scopes: scopes}
+	err = a.db.QueryRow(ctx, `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, creation_timestamp`, userID, name, hashToken(token), scopes).Scan(&apiToken.ID, &apiToken.CreationTimestamp)
+	if err != nil {
+		return models.APIToken{}, "", err
+	}
+
+	return apiToken, token, nil
+}
+
+func (a *AuthModule) ListAPITokens(ctx context.Context, userID int) ([]models.APIToken, error) {
+	rows, err := a.db.Query(ctx, `
+		SELECT id, name, scopes, creation_timestamp, last_used_at
+		FROM api_tokens
+		WHERE user_id = $1
+		ORDER BY creation_timestamp DESC, id DESC`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var token models.APIToken
+		if err := rows.Scan(&token.ID, &token.Name, &token.Scopes, &token.CreationTimestamp, &token.LastUsedAt); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (a *AuthModule) RevokeAPIToken(ctx context.Context, userID, tokenID int) error {
+	tag, err := a.db.Exec(ctx, "DELETE FROM api_tokens WHERE id = $1 AND user_id = $2", tokenID, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPITokenNotFound
+	}
+	return nil
+}
+
+// IsAPIToken tells API tokens apart from session tokens
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
+
+// ValidateAPIToken returns the owner and scopes of the token and records its use
+func (a *AuthModule) ValidateAPIToken(ctx context.Context, token string) (int, []string, error) {
+	var tokenID, userID int
+	var scopes []string
+	var lastUsedAt *time.Time
+	err := a.db.QueryRow(ctx, `
+		SELECT id, user_id, scopes, last_used_at
+		FROM api_tokens
+		WHERE token_hash = $1`, hashToken(token)).Scan(&tokenID, &userID, &scopes, &lastUsedAt)
+	if err == pgx.ErrNoRows {
+		return 0, nil, ErrInvalidToken
+	} else if err != nil {
+		return 0, nil, err
+	}
+
+	if lastUsedAt == nil || time.Since(*lastUsedAt) > apiTokenTouchInterval {
+		if _, err := a.db.Exec(ctx, "UPDATE api_tokens SET last_used_at = NOW() WHERE id = $1", tokenID); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return userID, scopes, nil
+}
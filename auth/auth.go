@@ -5,23 +5,48 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"errors"
+	"strconv"
 	"time"
 
+	"instagramplusbackend/internal/mailer"
+	"instagramplusbackend/internal/oidc"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthModule struct {
-	db    *pgxpool.Pool
-	redis *redis.Client
+	db             *pgxpool.Pool
+	redis          *redis.Client
+	mailer         mailer.Mailer
+	sessionSecrets []sessionSecret
+	lockout        *LoginLimiter
+	oidcProviders  map[string]*oidc.Provider
+
+	// legacySessionsUntil ends the migration of sessions stored under the raw token
+	legacySessionsUntil time.Time
 }
 
-func NewAuthModule(db *pgxpool.Pool, redis *redis.Client) *AuthModule {
-	return &AuthModule{
-		db:    db,
-		redis: redis,
+func NewAuthModule(db *pgxpool.Pool, redis *redis.Client, mailer mailer.Mailer, oidcProviders map[string]*oidc.Provider) (*AuthModule, error) {
+	sessionSecrets, err := loadSessionSecrets()
+	if err != nil {
+		return nil, err
 	}
+	legacySessionsUntil, err := loadLegacySessionsUntil(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthModule{
+		db:                  db,
+		redis:               redis,
+		mailer:              mailer,
+		sessionSecrets:      sessionSecrets,
+		legacySessionsUntil: legacySessionsUntil,
+		lockout:             NewLoginLimiter(redis, LockoutPolicyFromEnv()),
+		oidcProviders:       oidcProviders,
+	}, nil
 }
 
 func generateSecureToken(length int) (string, error) {
@@ -33,7 +58,7 @@ func generateSecureToken(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(randomBytes), nil
 }
 
-func (a *AuthModule) Register(ctx context.Context, username, password string, email string) (int, string, error) {
+func (a *AuthModule) Register(ctx context.Context, username, password string, email string, client ClientInfo) (int, string, error) {
 	var exists bool
 	err := a.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", username).Scan(&exists)
 	if err != nil {
@@ -57,13 +82,7 @@ func (a *AuthModule) Register(ctx context.Context, username, password string, em
 		return 0, "", err
 	}
 
-	token, err := generateSecureToken(32)
-	if err != nil {
-		return 0, "", err
-	}
-
-	key := "session:" + token
-	err = a.redis.Set(ctx, key, userID, 24*time.Hour).Err()
+	token, err := a.createSession(ctx, userID, client)
 	if err != nil {
 		return 0, "", err
 	}
@@ -71,25 +90,39 @@ func (a *AuthModule) Register(ctx context.Context, username, password string, em
 	return userID, token, nil
 }
 
-func (a *AuthModule) Login(ctx context.Context, username, password string) (int, string, error) {
+func (a *AuthModule) Login(ctx context.Context, username, password string, client ClientInfo) (int, string, error) {
+	if err := a.lockout.Check(ctx, username, client.IP); err != nil {
+		return 0, "", err
+	}
+
 	var userID int
 	var passwordHash string
-	err := a.db.QueryRow(ctx, "SELECT id, password FROM users WHERE username = $1", username).Scan(&userID, &passwordHash)
+	var totpEnabled bool
+	err := a.db.QueryRow(ctx, "SELECT id, password, totp_enabled FROM users WHERE username = $1", username).Scan(&userID, &passwordHash, &totpEnabled)
 	if err != nil {
-		return 0, "", errors.New("invalid credentials")
+		// Unknown usernames count as well, so lockouts do not reveal which accounts exist
+		return 0, "", a.loginFailed(ctx, username, client.IP)
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
-		return 0, "", errors.New("invalid credentials")
+		return 0, "", a.loginFailed(ctx, username, client.IP)
 	}
 
-	token, err := generateSecureToken(32)
-	if err != nil {
+	// With two-factor enabled the caller gets a pending token instead of a session.
+	// The counters are only reset once the second factor is verified, a password alone must not clear them.
+	if totpEnabled {
+		pendingToken, err := a.startTwoFactorLogin(ctx, userID)
+		if err != nil {
+			return 0, "", err
+		}
+		return userID, pendingToken, ErrTwoFactorRequired
+	}
+
+	if err := a.lockout.RecordSuccess(ctx, username); err != nil {
 		return 0, "", err
 	}
 
-	key := "session:" + token
-	err = a.redis.Set(ctx, key, userID, 24*time.Hour).Err()
+	token, err := a.createSession(ctx, userID, client)
 	if err != nil {
 		return 0, "", err
 	}
@@ -97,12 +130,17 @@ func (a *AuthModule) Login(ctx context.Context, username, password string) (int,
 	return userID, token, nil
 }
 
+// loginFailed counts the failure, the error is a LockoutError when the failure started a lockout
+func (a *AuthModule) loginFailed(ctx context.Context, username, ip string) error {
+	if err := a.lockout.RecordFailure(ctx, username, ip); err != nil {
+		return err
+	}
+	return errors.New("invalid credentials")
+}
+
 func (a *AuthModule) ValidateToken(ctx context.Context, token string) (string, error) {
-	key := "session:" + token
-	userID, err := a.redis.Get(ctx, key).Result()
-	if err == redis.Nil {
-		return "", errors.New("invalid token")
-	} else if err != nil {
+	key, userID, err := a.resolveSession(ctx, token)
+	if err != nil {
 		return "", err
 	}
 
@@ -112,23 +150,35 @@ func (a *AuthModule) ValidateToken(ctx context.Context, token string) (string, e
 		return "", err
 	}
 
+	userIDInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return "", err
+	}
+
 	// Update expiration only after some time
-	if ttl < 20*time.Hour {
-		err = a.redis.Expire(ctx, key, 24*time.Hour).Err()
-		if err != nil {
-			return "", err
-		}
+	if err := a.touchSession(ctx, token, key, userIDInt, ttl < sessionTTL-sessionRefreshAfter); err != nil {
+		return "", err
 	}
 	return userID, nil
 }
 
 func (a *AuthModule) Logout(ctx context.Context, token string) error {
-	key := "session:" + token
-	return a.redis.Del(ctx, key).Err()
+	_, userID, err := a.resolveSession(ctx, token)
+	if err == ErrInvalidToken {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	userIDInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return err
+	}
+	return a.deleteSession(ctx, userIDInt, SessionID(token))
 }
 
-// ChangePassword changes the user's password after verifying the old password
-func (a *AuthModule) ChangePassword(ctx context.Context, userID int, oldPassword, newPassword string) error {
+// ChangePassword changes the user's password after verifying the old password and logs out every other session
+func (a *AuthModule) ChangePassword(ctx context.Context, userID int, oldPassword, newPassword, currentSessionID string) error {
 	var passwordHash string
 	err := a.db.QueryRow(ctx, "SELECT password FROM users WHERE id = $1", userID).Scan(&passwordHash)
 	if err != nil {
@@ -144,10 +194,15 @@ func (a *AuthModule) ChangePassword(ctx context.Context, userID int, oldPassword
 	}
 
 	_, err = a.db.Exec(ctx, "UPDATE users SET password = $1 WHERE id = $2", string(hashedPassword), userID)
-	return err
+	if err != nil {
+		return err
+	}
+
+	return a.RevokeOtherSessions(ctx, userID, currentSessionID)
 }
 
-// ChangeEmail changes the user's email after verifying the password
+// ChangeEmail starts an email change after verifying the password.
+// The new email is kept pending and the old one stays active until the new one is confirmed.
 func (a *AuthModule) ChangeEmail(ctx context.Context, userID int, password, newEmail string) error {
 	var passwordHash string
 	err := a.db.QueryRow(ctx, "SELECT password FROM users WHERE id = $1", userID).Scan(&passwordHash)
@@ -158,17 +213,10 @@ func (a *AuthModule) ChangeEmail(ctx context.Context, userID int, password, newE
 		return errors.New("invalid password")
 	}
 
-	_, err = a.db.Exec(ctx, "UPDATE users SET email = $1 WHERE id = $2", newEmail, userID)
-	return err
-}
-
-//
-// TOKEN SIGNING FOR HASHED TOKENS IN REDIS
-//
-// func signToken(token, secretKey string) string {
-// 	h := hmac.New(sha256.New, []byte(secretKey))
-// 	h.Write([]byte(token))
-// 	signature := h.Sum(nil)
+	_, err = a.db.Exec(ctx, "UPDATE users SET pending_email = $1 WHERE id = $2", newEmail, userID)
+	if err != nil {
+		return err
+	}
 
-// 	return base64.URLEncoding.EncodeToString(signature)
-// }
+	return a.SendEmailVerification(ctx, userID, newEmail)
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"instagramplusbackend/internal/mailer"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+var ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+var ErrEmailAlreadyVerified = errors.New("email already verified")
+
+// SendEmailVerification emails a one-time confirmation link for the address to the user
+func (a *AuthModule) SendEmailVerification(ctx context.Context, userID int, email string) error {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return err
+	}
+
+	// The token is bound to the address, a link for a replaced pending email cannot confirm the newer one
+	key := "email_verification:" + hashToken(token)
+	if err := a.redis.Set(ctx, key, strconv.Itoa(userID)+":"+email, emailVerificationTTL).Err(); err != nil {
+		return err
+	}
+
+	link := AppURL() + "/verify-email?token=" + url.QueryEscape(token)
+	return a.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: "Open this link within the next 24 hours to confirm your email address:\n" + link + "\n\n" +
+			"If you did not use this address for an account, you can ignore this email.",
+	})
+}
+
+// ResendEmailVerification sends a new link for the pending email or, when there is none, the unverified current email
+func (a *AuthModule) ResendEmailVerification(ctx context.Context, userID int) error {
+	var email string
+	var pendingEmail *string
+	var verified bool
+	err := a.db.QueryRow(ctx, "SELECT email, pending_email, email_verified FROM users WHERE id = $1", userID).Scan(&email, &pendingEmail, &verified)
+	if err != nil {
+		return errors.New("user not found")
+	}
+
+	if pendingEmail != nil {
+		return a.SendEmailVerification(ctx, userID, *pendingEmail)
+	}
+	if verified {
+		return ErrEmailAlreadyVerified
+	}
+	return a.SendEmailVerification(ctx, userID, email)
+}
+
+// VerifyEmail consumes a verification token. Confirming a pending email makes it the account email.
+func (a *AuthModule) VerifyEmail(ctx context.Context, token string) error {
+	value, err := a.redis.GetDel(ctx, "email_verification:"+hashToken(token)).Result()
+	if err == redis.Nil {
+		return ErrInvalidVerificationToken
+	} else if err != nil {
+		return err
+	}
+
+	rawUserID, email, _ := strings.Cut(value, ":")
+	userID, err := strconv.Atoi(rawUserID)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+
+	var verifiedID int
+	err = a.db.QueryRow(ctx, `
+		UPDATE users
+		SET email = $2, pending_email = NULL, email_verified = TRUE
+		WHERE id = $1 AND (pending_email = $2 OR (email = $2 AND pending_email IS NULL))
+		RETURNING id`, userID, email).Scan(&verifiedID)
+	if err == pgx.ErrNoRows {
+		// The address was replaced since the link was sent
+		return ErrInvalidVerificationToken
+	}
+	return err
+}
+
+// IsEmailVerified reports whether the current email of the user is confirmed
+func (a *AuthModule) IsEmailVerified(ctx context.Context, userID int) (bool, error) {
+	var verified bool
+	err := a.db.QueryRow(ctx, "SELECT email_verified FROM users WHERE id = $1", userID).Scan(&verified)
+	return verified, err
+}
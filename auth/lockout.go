@@ -0,0 +1,163 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LockoutPolicy configures brute-force protection of the login
+type LockoutPolicy struct {
+	// MaxUsernameFailures locks an account after that many failed logins within Window
+	MaxUsernameFailures int64
+	// MaxIPFailures throttles a client address after that many failed logins within Window
+	MaxIPFailures int64
+	Window        time.Duration
+	// BaseDelay is the first lockout, every following lockout doubles up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// LockoutPolicyFromEnv reads the LOGIN_* variables, missing or invalid values keep the defaults
+func LockoutPolicyFromEnv() LockoutPolicy {
+	return LockoutPolicy{
+		MaxUsernameFailures: envInt("LOGIN_MAX_FAILURES_USERNAME", 5),
+		MaxIPFailures:       envInt("LOGIN_MAX_FAILURES_IP", 20),
+		Window:              envDuration("LOGIN_FAILURE_WINDOW", 15*time.Minute),
+		BaseDelay:           envDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+		MaxDelay:            envDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+	}
+}
+
+func envInt(name string, fallback int64) int64 {
+	value, err := strconv.ParseInt(os.Getenv(name), 10, 64)
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+const (
+	LockoutScopeUsername = "username"
+	LockoutScopeIP       = "ip"
+)
+
+// LockoutError is returned while logins for the username or the client address are blocked
+type LockoutError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *LockoutError) Error() string {
+	if e.Scope == LockoutScopeIP {
+		return fmt.Sprintf("too many failed login attempts, try again in %s", e.RetryAfter.Round(time.Second))
+	}
+	return fmt.Sprintf("account temporarily locked, try again in %s", e.RetryAfter.Round(time.Second))
+}
+
+// LoginLimiter counts failed logins per username and per client address in Redis.
+// It only needs redis.Cmdable, so it also runs against an in-process Redis stand-in.
+type LoginLimiter struct {
+	redis  redis.Cmdable
+	policy LockoutPolicy
+}
+
+func NewLoginLimiter(redis redis.Cmdable, policy LockoutPolicy) *LoginLimiter {
+	return &LoginLimiter{
+		redis:  redis,
+		policy: policy,
+	}
+}
+
+type lockoutSubject struct {
+	scope       string
+	id          string
+	maxFailures int64
+}
+
+func (l *LoginLimiter) subjects(username, ip string) []lockoutSubject {
+	subjects := []lockoutSubject{{LockoutScopeUsername, strings.ToLower(username), l.policy.MaxUsernameFailures}}
+	if ip != "" {
+		subjects = append(subjects, lockoutSubject{LockoutScopeIP, ip, l.policy.MaxIPFailures})
+	}
+	return subjects
+}
+
+// Check returns a LockoutError while the username or the address is locked
+func (l *LoginLimiter) Check(ctx context.Context, username, ip string) error {
+	for _, subject := range l.subjects(username, ip) {
+		ttl, err := l.redis.PTTL(ctx, "login_lock:"+subject.scope+":"+subject.id).Result()
+		if err != nil {
+			return err
+		}
+		if ttl > 0 {
+			return &LockoutError{Scope: subject.scope, RetryAfter: ttl}
+		}
+	}
+	return nil
+}
+
+// RecordFailure counts a failed login against every subject and returns a LockoutError when it starts a lockout,
+// the first one when several start at once. Each lockout within a day doubles the previous one.
+func (l *LoginLimiter) RecordFailure(ctx context.Context, username, ip string) error {
+	var lockout *LockoutError
+	for _, subject := range l.subjects(username, ip) {
+		failuresKey := "login_failures:" + subject.scope + ":" + subject.id
+
+		pipe := l.redis.TxPipeline()
+		failures := pipe.Incr(ctx, failuresKey)
+		pipe.ExpireNX(ctx, failuresKey, l.policy.Window)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+		if failures.Val() < subject.maxFailures {
+			continue
+		}
+
+		lockoutsKey := "login_lockouts:" + subject.scope + ":" + subject.id
+		pipe = l.redis.TxPipeline()
+		lockouts := pipe.Incr(ctx, lockoutsKey)
+		pipe.Expire(ctx, lockoutsKey, 24*time.Hour)
+		pipe.Del(ctx, failuresKey)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return err
+		}
+
+		delay := l.policy.BaseDelay
+		for i := int64(1); i < lockouts.Val() && delay < l.policy.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, l.policy.MaxDelay)
+
+		if err := l.redis.Set(ctx, "login_lock:"+subject.scope+":"+subject.id, 1, delay).Err(); err != nil {
+			return err
+		}
+		if lockout == nil {
+			lockout = &LockoutError{Scope: subject.scope, RetryAfter: delay}
+		}
+	}
+	if lockout != nil {
+		return lockout
+	}
+	return nil
+}
+
+// RecordSuccess resets the counters of the username. The address counters are kept,
+// otherwise an attacker could clear them by logging into an account of their own.
+func (l *LoginLimiter) RecordSuccess(ctx context.Context, username string) error {
+	id := strings.ToLower(username)
+	return l.redis.Del(ctx, "login_failures:"+LockoutScopeUsername+":"+id, "login_lockouts:"+LockoutScopeUsername+":"+id).Err()
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+var testLockoutPolicy = LockoutPolicy{
+	MaxUsernameFailures: 3,
+	MaxIPFailures:       5,
+	Window:              15 * time.Minute,
+	BaseDelay:           time.Minute,
+	MaxDelay:            5 * time.Minute,
+}
+
+func newTestLoginLimiter(t *testing.T) (*LoginLimiter, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewLoginLimiter(client, testLockoutPolicy), server
+}
+
+// fail records failures until one returns an error
+func fail(t *testing.T, l *LoginLimiter, username, ip string, times int) error {
+	t.Helper()
+	for i := 0; i < times; i++ {
+		if err := l.RecordFailure(context.Background(), username, ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lockoutError(t *testing.T, err error) *LockoutError {
+	t.Helper()
+	var lockoutErr *LockoutError
+	if !errors.As(err, &lockoutErr) {
+		t.Fatalf("error = %v, want a LockoutError", err)
+	}
+	return lockoutErr
+}
+
+func TestLoginLimiterLocksUsername(t *testing.T) {
+	l, _ := newTestLoginLimiter(t)
+	ctx := context.Background()
+
+	if err := fail(t, l, "alice", "10.0.0.1", 2); err != nil {
+		t.Fatalf("failures below the limit: %v", err)
+	}
+	if err := l.Check(ctx, "alice", "10.0.0.1"); err != nil {
+		t.Fatalf("check below the limit: %v", err)
+	}
+
+	lockout := lockoutError(t, fail(t, l, "alice", "10.0.0.1", 1))
+	if lockout.Scope != LockoutScopeUsername || lockout.RetryAfter != time.Minute {
+		t.Fatalf("lockout = %+v", lockout)
+	}
+
+	// Usernames are case insensitive and the lock holds from any address
+	lockout = lockoutError(t, l.Check(ctx, "ALICE", "10.0.0.2"))
+	if lockout.Scope != LockoutScopeUsername || lockout.RetryAfter <= 0 {
+		t.Fatalf("check during lockout = %+v", lockout)
+	}
+	if err := l.Check(ctx, "bob", "10.0.0.2"); err != nil {
+		t.Fatalf("other account: %v", err)
+	}
+}
+
+func TestLoginLimiterDoublesLockouts(t *testing.T) {
+	l, server := newTestLoginLimiter(t)
+
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		lockout := lockoutError(t, fail(t, l, "alice", "", 3))
+		if lockout.RetryAfter != want {
+			t.Fatalf("lockout = %v, want %v", lockout.RetryAfter, want)
+		}
+		server.FastForward(want)
+		if err := l.Check(context.Background(), "alice", ""); err != nil {
+			t.Fatalf("check after the lockout: %v", err)
+		}
+	}
+
+	// Lockouts are forgotten after a day
+	server.FastForward(24 * time.Hour)
+	if lockout := lockoutError(t, fail(t, l, "alice", "", 3)); lockout.RetryAfter != time.Minute {
+		t.Fatalf("lockout after a day = %v, want %v", lockout.RetryAfter, time.Minute)
+	}
+}
+
+func TestLoginLimiterWindow(t *testing.T) {
+	l, server := newTestLoginLimiter(t)
+
+	if err := fail(t, l, "alice", "", 2); err != nil {
+		t.Fatal(err)
+	}
+	server.FastForward(testLockoutPolicy.Window)
+	if err := fail(t, l, "alice", "", 2); err != nil {
+		t.Fatalf("failures of an expired window were counted: %v", err)
+	}
+}
+
+func TestLoginLimiterThrottlesAddress(t *testing.T) {
+	l, _ := newTestLoginLimiter(t)
+	ctx := context.Background()
+
+	usernames := []string{"a", "b", "c", "d", "e"}
+	var err error
+	for _, username := range usernames {
+		if err = l.RecordFailure(ctx, username, "10.0.0.1"); err != nil {
+			break
+		}
+	}
+	lockout := lockoutError(t, err)
+	if lockout.Scope != LockoutScopeIP {
+		t.Fatalf("lockout scope = %q, want %q", lockout.Scope, LockoutScopeIP)
+	}
+	if lockout := lockoutError(t, l.Check(ctx, "f", "10.0.0.1")); lockout.Scope != LockoutScopeIP {
+		t.Fatalf("check scope = %q", lockout.Scope)
+	}
+	if err := l.Check(ctx, "f", "10.0.0.2"); err != nil {
+		t.Fatalf("other address: %v", err)
+	}
+}
+
+func TestLoginLimiterCountsEverySubject(t *testing.T) {
+	l, _ := newTestLoginLimiter(t)
+	ctx := context.Background()
+
+	if err := fail(t, l, "a", "10.0.0.1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := fail(t, l, "b", "10.0.0.1", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failure locks the username and the address at once, the username lockout is reported
+	lockout := lockoutError(t, l.RecordFailure(ctx, "a", "10.0.0.1"))
+	if lockout.Scope != LockoutScopeUsername {
+		t.Fatalf("lockout scope = %q, want %q", lockout.Scope, LockoutScopeUsername)
+	}
+	if lockout := lockoutError(t, l.Check(ctx, "c", "10.0.0.1")); lockout.Scope != LockoutScopeIP {
+		t.Fatalf("check scope = %q, want the address to be locked as well", lockout.Scope)
+	}
+}
+
+func TestLoginLimiterRecordSuccess(t *testing.T) {
+	l, _ := newTestLoginLimiter(t)
+	ctx := context.Background()
+
+	if err := fail(t, l, "alice", "10.0.0.1", 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RecordSuccess(ctx, "Alice"); err != nil {
+		t.Fatal(err)
+	}
+	// The username counter starts over
+	if err := fail(t, l, "alice", "10.0.0.1", 2); err != nil {
+		t.Fatalf("username failures were kept after a success: %v", err)
+	}
+	// The address counter is kept, four failures so far
+	if lockout := lockoutError(t, fail(t, l, "bob", "10.0.0.1", 1)); lockout.Scope != LockoutScopeIP {
+		t.Fatalf("lockout scope = %q, want %q", lockout.Scope, LockoutScopeIP)
+	}
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/oidc"
+	"instagramplusbackend/internal/utils"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/redis/go-redis/v9"
+)
+
+// OIDCStateTTL bounds the time between the redirect to the provider and the callback
+const OIDCStateTTL = 10 * time.Minute
+
+var ErrInvalidOIDCState = errors.New("invalid or expired login attempt")
+
+var ErrIdentityLinkedElsewhere = errors.New("this identity is linked to another account")
+
+var ErrProviderAlreadyLinked = errors.New("an identity of this provider is already linked")
+
+var ErrIdentityNotFound = errors.New("identity not found")
+
+var ErrLastLoginMethod = errors.New("cannot unlink the only way to log in, set a password first")
+
+var ErrEmailInUse = errors.New("the email is already used by an account, log in and link the provider instead")
+
+// oidcState is kept in Redis between the redirect to the provider and the callback
+type oidcState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// LinkUserID is set when a logged in user links the identity to their account
+	LinkUserID int `json:"link_user_id,omitempty"`
+}
+
+// OIDCResult describes how the callback was handled. Token is empty when an identity was linked
+// and is the pending token of the two-factor login when CompleteOIDC returns ErrTwoFactorRequired.
+type OIDCResult struct {
+	UserID  int
+	Token   string
+	Created bool
+	Linked  bool
+}
+
+// OIDCProviders lists the configured provider names
+func (a *AuthModule) OIDCProviders() []string {
+	names := []string{}
+	for name := range a.oidcProviders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BeginOIDC returns the provider URL that starts the login, or the linking when linkUserID is set, and the state.
+// The caller has to bind the state to the browser, such as with a cookie, and pass it back to CompleteOIDC.
+func (a *AuthModule) BeginOIDC(ctx context.Context, providerName string, linkUserID int) (string, string, error) {
+	provider, ok := a.oidcProviders[providerName]
+	if !ok {
+		return "", "", oidc.ErrUnknownProvider
+	}
+
+	state, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+	verifier, err := oidc.RandomString()
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := json.Marshal(oidcState{Provider: providerName, Nonce: nonce, Verifier: verifier, LinkUserID: linkUserID})
+	if err != nil {
+		return "", "", err
+	}
+	if err := a.redis.Set(ctx, "oidc_state:"+state, data, OIDCStateTTL).Err(); err != nil {
+		return "", "", err
+	}
+
+	target, err := provider.AuthCodeURL(ctx, state, nonce, oidc.CodeChallenge(verifier))
+	if err != nil {
+		return "", "", err
+	}
+	return target, state, nil
+}
+
+// CompleteOIDC handles the provider callback. Known identities log in, unknown ones get a new account
+// unless the flow was started for linking. browserState is the state BeginOIDC bound to the browser,
+// a callback in another browser is rejected so nobody can be logged into or linked to a foreign identity.
+// Users with two-factor authentication get a pending token and ErrTwoFactorRequired instead of a session.
+func (a *AuthModule) CompleteOIDC(ctx context.Context, providerName, state, browserState, code string, client ClientInfo) (OIDCResult, error) {
+	provider, ok := a.oidcProviders[providerName]
+	if !ok {
+		return OIDCResult{}, oidc.ErrUnknownProvider
+	}
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(browserState)) != 1 {
+		return OIDCResult{}, ErrInvalidOIDCState
+	}
+
+	data, err := a.redis.GetDel(ctx, "oidc_state:"+state).Bytes()
+	if err == redis.Nil {
+		return OIDCResult{}, ErrInvalidOIDCState
+	} else if err != nil {
+		return OIDCResult{}, err
+	}
+	var pending oidcState
+	if err := json.Unmarshal(data, &pending); err != nil || pending.Provider != providerName {
+		return OIDCResult{}, ErrInvalidOIDCState
+	}
+
+	claims, err := provider.Exchange(ctx, code, pending.Verifier, pending.Nonce)
+	if err != nil {
+		return OIDCResult{}, err
+	}
+
+	if pending.LinkUserID != 0 {
+		if err := a.linkIdentity(ctx, pending.LinkUserID, providerName, claims); err != nil {
+			return OIDCResult{}, err
+		}
+		return OIDCResult{UserID: pending.LinkUserID, Linked: true}, nil
+	}
+
+	result := OIDCResult{}
+	var totpEnabled bool
+	err = a.db.QueryRow(ctx, `
+		SELECT i.user_id, u.totp_enabled
+		FROM user_identities i
+		JOIN users u ON u.id = i.user_id
+		WHERE i.provider = $1 AND i.subject = $2`, providerName, claims.Subject).Scan(&result.UserID, &totpEnabled)
+	if err == pgx.ErrNoRows {
+		result.UserID, err = a.createOIDCUser(ctx, providerName, claims)
+		result.Created = true
+	}
+	if err != nil {
+		return OIDCResult{}, err
+	}
+
+	// The provider replaces the password, not the second factor
+	if totpEnabled {
+		result.Token, err = a.startTwoFactorLogin(ctx, result.UserID)
+		if err != nil {
+			return OIDCResult{}, err
+		}
+		return result, ErrTwoFactorRequired
+	}
+
+	result.Token, err = a.createSession(ctx, result.UserID, client)
+	if err != nil {
+		return OIDCResult{}, err
+	}
+	return result, nil
+}
+
+func (a *AuthModule) linkIdentity(ctx context.Context, userID int, providerName string, claims *oidc.Claims) error {
+	var ownerID int
+	err := a.db.QueryRow(ctx, "SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2", providerName, claims.Subject).Scan(&ownerID)
+	if err == nil {
+		if ownerID == userID {
+			return nil
+		}
+		return ErrIdentityLinkedElsewhere
+	} else if err != pgx.ErrNoRows {
+		return err
+	}
+
+	_, err = a.db.Exec(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)`, userID, providerName, claims.Subject, claims.Email)
+	if utils.IsDuplicatePgxError(err) {
+		return ErrProviderAlreadyLinked
+	}
+	return err
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_.]+`)
+
+// createOIDCUser registers a password-less account and fills the profile from the claims
+func (a *AuthModule) createOIDCUser(ctx context.Context, providerName string, claims *oidc.Claims) (int, error) {
+	// Accounts are never merged by email, the provider could vouch for an address it does not own
+	if claims.Email != "" {
+		var emailInUse bool
+		if err := a.db.QueryRow(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", claims.Email).Scan(&emailInUse); err != nil {
+			return 0, err
+		}
+		if emailInUse {
+			return 0, ErrEmailInUse
+		}
+	}
+
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(strings.ToLower(base), "")
+	if len(base) < 3 {
+		base = "user"
+	}
+	base = base[:min(len(base), 15)]
+
+	name, surname := claims.GivenName, claims.FamilyName
+	if name == "" {
+		name, surname, _ = strings.Cut(claims.Name, " ")
+	}
+	if name == "" {
+		name = base
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		username := base
+		if attempt > 0 {
+			username = base + strconv.Itoa(rand.IntN(10000))
+		}
+
+		userID, err := a.insertOIDCUser(ctx, username, truncateRunes(name, 20), truncateRunes(surname, 20), providerName, claims)
+		if utils.IsDuplicatePgxError(err) {
+			continue
+		}
+		return userID, err
+	}
+	return 0, errors.New("could not find a free username")
+}
+
+func (a *AuthModule) insertOIDCUser(ctx context.Context, username, name, surname, providerName string, claims *oidc.Claims) (int, error) {
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	// An empty password hash never matches, these accounts log in through the provider or after a password reset
+	var userID int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO users (username, password, email, email_verified)
+		VALUES ($1, '', $2, $3)
+		RETURNING id`, username, claims.Email, claims.Email != "" && claims.EmailVerified).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_profiles (user_id, name, surname, description, profile_image_url, gender, birth)
+		VALUES ($1, $2, $3, '', '', 'other', NULL)`, userID, name, surname)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)`, userID, providerName, claims.Subject, claims.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit(ctx)
+}
+
+func truncateRunes(value string, limit int) string {
+	if utf8.RuneCountInString(value) <= limit {
+		return value
+	}
+	return string([]rune(value)[:limit])
+}
+
+func (a *AuthModule) ListIdentities(ctx context.Context, userID int) ([]models.Identity, error) {
+	rows, err := a.db.Query(ctx, `
+		SELECT provider, email, creation_timestamp
+		FROM user_identities
+		WHERE user_id = $1
+		ORDER BY provider`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []models.Identity{}
+	for rows.Next() {
+		var identity models.Identity
+		if err := rows.Scan(&identity.Provider, &identity.Email, &identity.CreationTimestamp); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+
+// UnlinkIdentity removes a linked provider, the account has to keep a password or another provider
+func (a *AuthModule) UnlinkIdentity(ctx context.Context, userID int, providerName string) error {
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var hasPassword bool
+	var otherIdentities int
+	err = tx.QueryRow(ctx, `
+		SELECT u.password <> '', (SELECT COUNT(*) FROM user_identities i WHERE i.user_id = u.id AND i.provider <> $2)
+		FROM users u
+		WHERE u.id = $1
+		FOR UPDATE`, userID, providerName).Scan(&hasPassword, &otherIdentities)
+	if err != nil {
+		return err
+	}
+	if !hasPassword && otherIdentities == 0 {
+		return ErrLastLoginMethod
+	}
+
+	tag, err := tx.Exec(ctx, "DELETE FROM user_identities WHERE user_id = $1 AND provider = $2", userID, providerName)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+	return tx.Commit(ctx)
+}
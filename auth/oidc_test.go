@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"instagramplusbackend/internal/oidc"
+)
+
+// newTestOIDCProvider serves only the discovery document, enough to start a flow
+func newTestOIDCProvider(t *testing.T) *oidc.Provider {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"jwks_uri":               server.URL + "/jwks",
+		})
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := oidc.NewProvider(oidc.Config{
+		Name:        "test",
+		Issuer:      server.URL,
+		ClientID:    "instagramplus",
+		RedirectURL: "http://localhost:5069/auth/oidc/test/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestCompleteOIDCRequiresBrowserState(t *testing.T) {
+	a, server := newTestAuthModule(t)
+	a.oidcProviders = map[string]*oidc.Provider{"test": newTestOIDCProvider(t)}
+	ctx := context.Background()
+
+	target, state, err := a.BeginOIDC(ctx, "test", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(target)
+	if err != nil || parsed.Query().Get("state") != state {
+		t.Fatalf("authorization URL %s does not carry the state %q", target, state)
+	}
+	if !server.Exists("oidc_state:" + state) {
+		t.Fatal("state was not stored")
+	}
+
+	// A callback opened in another browser, e.g. by a forged link, has no or another state cookie
+	otherState, _ := oidc.RandomString()
+	for _, browserState := range []string{"", otherState} {
+		_, err := a.CompleteOIDC(ctx, "test", state, browserState, "code", ClientInfo{})
+		if !errors.Is(err, ErrInvalidOIDCState) {
+			t.Fatalf("browser state %q: %v, want ErrInvalidOIDCState", browserState, err)
+		}
+	}
+	if !server.Exists("oidc_state:" + state) {
+		t.Fatal("a rejected callback consumed the state")
+	}
+
+	if _, err := a.CompleteOIDC(ctx, "test", "", "", "code", ClientInfo{}); !errors.Is(err, ErrInvalidOIDCState) {
+		t.Fatalf("empty state: %v, want ErrInvalidOIDCState", err)
+	}
+	if _, _, err := a.BeginOIDC(ctx, "unknown", 0); !errors.Is(err, oidc.ErrUnknownProvider) {
+		t.Fatalf("unknown provider: %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"instagramplusbackend/internal/mailer"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const passwordResetTTL = time.Hour
+
+var ErrInvalidResetToken = errors.New("invalid or expired reset token")
+
+// ErrAmbiguousResetEmail means several accounts verified the address, no reset link is sent for any of them
+var ErrAmbiguousResetEmail = errors.New("email is verified by several accounts")
+
+// hashToken is used for one-time tokens, only the hash is stored so a Redis dump does not leak usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AppURL is the frontend base URL used in emailed links and redirects
+func AppURL() string {
+	if value := os.Getenv("APP_URL"); value != "" {
+		return value
+	}
+	return "http://localhost:5173"
+}
+
+// RequestPasswordReset emails a one-time reset link to the owner of the address.
+// Only verified addresses receive links, an unverified one may belong to someone else.
+// Unknown addresses are not reported, so the endpoint cannot be used to find registered emails.
+func (a *AuthModule) RequestPasswordReset(ctx context.Context, email string) error {
+	rows, err := a.db.Query(ctx, "SELECT id FROM users WHERE email = $1 AND email_verified LIMIT 2", email)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	userIDs := []int{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			return err
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(userIDs) == 0 {
+		return nil
+	} else if len(userIDs) > 1 {
+		return ErrAmbiguousResetEmail
+	}
+	userID := userIDs[0]
+
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return err
+	}
+	tokenHash := hashToken(token)
+
+	// Only the latest link of a user stays valid
+	userKey := "password_reset_user:" + strconv.Itoa(userID)
+	previous, err := a.redis.Get(ctx, userKey).Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	pipe := a.redis.TxPipeline()
+	if previous != "" {
+		pipe.Del(ctx, "password_reset:"+previous)
+	}
+	pipe.Set(ctx, "password_reset:"+tokenHash, userID, passwordResetTTL)
+	pipe.Set(ctx, userKey, tokenHash, passwordResetTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	link := AppURL() + "/reset-password?token=" + url.QueryEscape(token)
+	return a.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password of your account.\n\n" +
+			"Open this link within the next hour to choose a new password:\n" + link + "\n\n" +
+			"If it was not you, you can ignore this email.",
+	})
+}
+
+// ResetPassword sets a new password with a reset token and logs the user out everywhere,
+// API tokens are deleted with the password change. The token is consumed on the first attempt.
+func (a *AuthModule) ResetPassword(ctx context.Context, token, newPassword string) error {
+	userID, err := a.redis.GetDel(ctx, "password_reset:"+hashToken(token)).Int()
+	if err == redis.Nil {
+		return ErrInvalidResetToken
+	} else if err != nil {
+		return err
+	}
+	a.redis.Del(ctx, "password_reset_user:"+strconv.Itoa(userID))
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "UPDATE users SET password = $1 WHERE id = $2", string(hashedPassword), userID)
+	if err != nil {
+		return err
+	}
+	// Whoever took over the account may have created tokens that would outlive the new password
+	_, err = tx.Exec(ctx, "DELETE FROM api_tokens WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	return a.RevokeAllSessions(ctx, userID)
+}
@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionTokenBytes is the entropy of session tokens, they are the URL-safe base64 of that many random bytes
+const sessionTokenBytes = 32
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// sessionSecret is an HMAC key for session keys, the key ID is part of every Redis key signed with it
+type sessionSecret struct {
+	id  string
+	key []byte
+}
+
+// loadSessionSecrets reads SESSION_SECRETS, a comma separated list of "<key id>:<secret>" pairs.
+// The first secret signs new sessions, the others are only used to find and migrate older sessions.
+// Without secrets a random one is used, which is refused in release mode.
+func loadSessionSecrets() ([]sessionSecret, error) {
+	secrets := []sessionSecret{}
+	for _, entry := range strings.Split(os.Getenv("SESSION_SECRETS"), ",") {
+		id, secret, found := strings.Cut(strings.TrimSpace(entry), ":")
+		if !found || id == "" || secret == "" {
+			continue
+		}
+		secrets = append(secrets, sessionSecret{id: id, key: []byte(secret)})
+	}
+
+	if len(secrets) == 0 {
+		if gin.Mode() == gin.ReleaseMode {
+			return nil, errors.New("SESSION_SECRETS is required in release mode")
+		}
+		log.Println("SESSION_SECRETS is not set, using a random secret: sessions will not survive a restart")
+		randomBytes := make([]byte, 32)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, sessionSecret{id: "ephemeral", key: randomBytes})
+	}
+	return secrets, nil
+}
+
+// loadLegacySessionsUntil returns the end of the window in which sessions stored under the raw token are still
+// accepted and migrated. It lasts one sessionTTL from startup, when every legacy session has expired or been
+// migrated. SESSION_LEGACY_UNTIL (RFC 3339) can only end it earlier, a later value is ignored.
+func loadLegacySessionsUntil(now time.Time) (time.Time, error) {
+	until := now.Add(sessionTTL)
+	value := os.Getenv("SESSION_LEGACY_UNTIL")
+	if value == "" {
+		return until, nil
+	}
+	configured, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, errors.New("invalid SESSION_LEGACY_UNTIL value: " + err.Error())
+	}
+	if configured.Before(until) {
+		return configured, nil
+	}
+	return until, nil
+}
+
+// validSessionToken reports whether the token has the shape of the tokens createSession hands out.
+// Anything else, in particular a Redis key such as "<key id>:<hmac>", is never looked up.
+func validSessionToken(token string) bool {
+	if len(token) != base64.URLEncoding.EncodedLen(sessionTokenBytes) {
+		return false
+	}
+	decoded, err := base64.URLEncoding.Strict().DecodeString(token)
+	return err == nil && len(decoded) == sessionTokenBytes
+}
+
+func signToken(token string, secret sessionSecret) string {
+	h := hmac.New(sha256.New, secret.key)
+	h.Write([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// sessionKey is the Redis key of a session signed with the current secret, the raw token is never stored
+func (a *AuthModule) sessionKey(token string) string {
+	current := a.sessionSecrets[0]
+	return "session:" + current.id + ":" + signToken(token, current)
+}
+
+// resolveSession finds the session of a token and returns its key and user ID.
+// Sessions stored under a rotated secret or under the raw token are renamed to the current key on the way.
+func (a *AuthModule) resolveSession(ctx context.Context, token string) (string, string, error) {
+	if !validSessionToken(token) {
+		return "", "", ErrInvalidToken
+	}
+
+	key := a.sessionKey(token)
+	userID, err := a.redis.Get(ctx, key).Result()
+	if err == nil {
+		return key, userID, nil
+	} else if err != redis.Nil {
+		return "", "", err
+	}
+
+	candidates := []string{}
+	for _, secret := range a.sessionSecrets[1:] {
+		candidates = append(candidates, "session:"+secret.id+":"+signToken(token, secret))
+	}
+	// Sessions created before hashing used the raw token as key, they are migrated until the window closes
+	if time.Now().Before(a.legacySessionsUntil) {
+		candidates = append(candidates, "session:"+token)
+	}
+
+	for _, oldKey := range candidates {
+		userID, err := a.redis.Get(ctx, oldKey).Result()
+		if err == redis.Nil {
+			continue
+		} else if err != nil {
+			return "", "", err
+		}
+
+		if err := a.redis.Rename(ctx, oldKey, key).Err(); err != nil {
+			// A concurrent request may have migrated the session already
+			if exists, existsErr := a.redis.Exists(ctx, key).Result(); existsErr != nil || exists == 0 {
+				return "", "", err
+			}
+		}
+		if err := a.redis.HSet(ctx, sessionInfoKey(SessionID(token)), "key", key).Err(); err != nil {
+			return "", "", err
+		}
+		return key, userID, nil
+	}
+
+	return "", "", ErrInvalidToken
+}
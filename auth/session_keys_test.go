@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestAuthModule(t *testing.T) (*AuthModule, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return &AuthModule{
+		redis: client,
+		sessionSecrets: []sessionSecret{
+			{id: "k2", key: []byte("current secret")},
+			{id: "k1", key: []byte("rotated secret")},
+		},
+	}, server
+}
+
+func newTestToken(t *testing.T) string {
+	token, err := generateSecureToken(sessionTokenBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestResolveSessionCurrentAndRotatedSecret(t *testing.T) {
+	a, server := newTestAuthModule(t)
+	ctx := context.Background()
+
+	current := newTestToken(t)
+	server.Set(a.sessionKey(current), "7")
+	key, userID, err := a.resolveSession(ctx, current)
+	if err != nil || userID != "7" || key != a.sessionKey(current) {
+		t.Fatalf("current session = %q, %q, %v", key, userID, err)
+	}
+
+	rotated := newTestToken(t)
+	oldKey := "session:k1:" + signToken(rotated, a.sessionSecrets[1])
+	server.Set(oldKey, "8")
+	key, userID, err = a.resolveSession(ctx, rotated)
+	if err != nil || userID != "8" || key != a.sessionKey(rotated) {
+		t.Fatalf("rotated session = %q, %q, %v", key, userID, err)
+	}
+	if server.Exists(oldKey) || !server.Exists(a.sessionKey(rotated)) {
+		t.Error("rotated session was not renamed to the current key")
+	}
+}
+
+func TestResolveSessionRejectsRedisKeys(t *testing.T) {
+	a, server := newTestAuthModule(t)
+	a.legacySessionsUntil = time.Now().Add(time.Hour)
+	ctx := context.Background()
+
+	victim := newTestToken(t)
+	victimKey := a.sessionKey(victim)
+	server.Set(victimKey, "7")
+
+	// A leaked Redis key must not work as a token, the legacy lookup would find "session:" + token
+	leaked := strings.TrimPrefix(victimKey, "session:")
+	if _, _, err := a.resolveSession(ctx, leaked); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("leaked key as token: %v, want ErrInvalidToken", err)
+	}
+	if !server.Exists(victimKey) {
+		t.Fatal("the victim's session was moved")
+	}
+
+	for _, token := range []string{"", "short", strings.Repeat("a", 43) + ":", victim[:43] + "A"} {
+		if _, _, err := a.resolveSession(ctx, token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("token %q: %v, want ErrInvalidToken", token, err)
+		}
+	}
+}
+
+func TestResolveSessionLegacyWindow(t *testing.T) {
+	a, server := newTestAuthModule(t)
+	ctx := context.Background()
+
+	closed := newTestToken(t)
+	server.Set("session:"+closed, "7")
+	if _, _, err := a.resolveSession(ctx, closed); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("legacy session after the window: %v, want ErrInvalidToken", err)
+	}
+
+	a.legacySessionsUntil = time.Now().Add(time.Hour)
+	open := newTestToken(t)
+	server.Set("session:"+open, "8")
+	key, userID, err := a.resolveSession(ctx, open)
+	if err != nil || userID != "8" || key != a.sessionKey(open) {
+		t.Fatalf("legacy session in the window = %q, %q, %v", key, userID, err)
+	}
+	if server.Exists("session:" + open) {
+		t.Error("legacy session was not migrated")
+	}
+}
+
+func TestLoadSessionSecrets(t *testing.T) {
+	t.Setenv("SESSION_SECRETS", "k2:new, k1:old,broken")
+	secrets, err := loadSessionSecrets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 2 || secrets[0].id != "k2" || string(secrets[1].key) != "old" {
+		t.Fatalf("secrets = %+v", secrets)
+	}
+
+	t.Setenv("SESSION_SECRETS", "")
+	if secrets, err := loadSessionSecrets(); err != nil || len(secrets) != 1 {
+		t.Fatalf("random secret outside release mode = %+v, %v", secrets, err)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	defer gin.SetMode(gin.TestMode)
+	if _, err := loadSessionSecrets(); err == nil {
+		t.Fatal("missing SESSION_SECRETS was accepted in release mode")
+	}
+}
+
+func TestLoadLegacySessionsUntil(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		value string
+		want  time.Time
+	}{
+		{"unset", "", now.Add(sessionTTL)},
+		{"shorter", "2025-06-01T18:00:00Z", now.Add(6 * time.Hour)},
+		{"already over", "2025-05-01T00:00:00Z", time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)},
+		// The window cannot outlast the sessions it migrates
+		{"longer", "2025-07-01T00:00:00Z", now.Add(sessionTTL)},
+	}
+	for _, test := range tests {
+		t.Setenv("SESSION_LEGACY_UNTIL", test.value)
+		if until, err := loadLegacySessionsUntil(now); err != nil || !until.Equal(test.want) {
+			t.Errorf("%s: window = %v, %v, want %v", test.name, until, err, test.want)
+		}
+	}
+
+	t.Setenv("SESSION_LEGACY_UNTIL", "next week")
+	if _, err := loadLegacySessionsUntil(now); err == nil {
+		t.Fatal("invalid SESSION_LEGACY_UNTIL was accepted")
+	}
+}
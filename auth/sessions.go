@@ -0,0 +1,199 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"instagramplusbackend/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	sessionTTL = 24 * time.Hour
+	// sessionRefreshAfter delays TTL refreshes so they do not happen on every request
+	sessionRefreshAfter = 4 * time.Hour
+	maxUserAgentLength  = 255
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ClientInfo describes the device a session was created from
+type ClientInfo struct {
+	UserAgent string
+	IP        string
+}
+
+// SessionID is the public identifier of a session, derived from the token so the token itself is never exposed
+func SessionID(token string) string {
+	return hashToken(token)[:32]
+}
+
+func sessionInfoKey(sessionID string) string {
+	return "session_info:" + sessionID
+}
+
+func userSessionsKey(userID int) string {
+	return "user_sessions:" + strconv.Itoa(userID)
+}
+
+// createSession stores a new session with its metadata and indexes it under the user
+func (a *AuthModule) createSession(ctx context.Context, userID int, client ClientInfo) (string, error) {
+	token, err := generateSecureToken(sessionTokenBytes)
+	if err != nil {
+		return "", err
+	}
+
+	userAgent := client.UserAgent
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	sessionID := SessionID(token)
+	key := a.sessionKey(token)
+	now := time.Now().Unix()
+	pipe := a.redis.TxPipeline()
+	pipe.Set(ctx, key, userID, sessionTTL)
+	pipe.HSet(ctx, sessionInfoKey(sessionID),
+		"user_id", userID,
+		"key", key,
+		"user_agent", userAgent,
+		"ip", client.IP,
+		"created_at", now,
+		"last_seen_at", now)
+	pipe.Expire(ctx, sessionInfoKey(sessionID), sessionTTL)
+	pipe.SAdd(ctx, userSessionsKey(userID), sessionID)
+	pipe.Expire(ctx, userSessionsKey(userID), sessionTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// touchSession records activity on a session. Sessions created before the index existed are adopted into it.
+func (a *AuthModule) touchSession(ctx context.Context, token, key string, userID int, refresh bool) error {
+	sessionID := SessionID(token)
+	infoKey := sessionInfoKey(sessionID)
+
+	pipe := a.redis.Pipeline()
+	pipe.HSetNX(ctx, infoKey, "user_id", userID)
+	pipe.HSetNX(ctx, infoKey, "key", key)
+	pipe.HSetNX(ctx, infoKey, "created_at", time.Now().Unix())
+	pipe.HSet(ctx, infoKey, "last_seen_at", time.Now().Unix())
+	pipe.SAdd(ctx, userSessionsKey(userID), sessionID)
+	if refresh {
+		pipe.Expire(ctx, key, sessionTTL)
+		pipe.Expire(ctx, infoKey, sessionTTL)
+		pipe.Expire(ctx, userSessionsKey(userID), sessionTTL)
+	} else {
+		pipe.ExpireNX(ctx, infoKey, sessionTTL)
+		pipe.ExpireNX(ctx, userSessionsKey(userID), sessionTTL)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// ListSessions returns the active sessions of the user, most recently used first
+func (a *AuthModule) ListSessions(ctx context.Context, userID int, currentSessionID string) ([]models.Session, error) {
+	sessionIDs, err := a.redis.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	pipe := a.redis.Pipeline()
+	infoCmds := make([]*redis.MapStringStringCmd, len(sessionIDs))
+	for i, sessionID := range sessionIDs {
+		infoCmds[i] = pipe.HGetAll(ctx, sessionInfoKey(sessionID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	sessions := []models.Session{}
+	for i, sessionID := range sessionIDs {
+		info := infoCmds[i].Val()
+		active := info["key"] != "" && info["user_id"] == strconv.Itoa(userID)
+		if active {
+			exists, err := a.redis.Exists(ctx, info["key"]).Result()
+			if err != nil {
+				return nil, err
+			}
+			active = exists == 1
+		}
+		// Expired sessions leave their id behind in the index
+		if !active {
+			a.redis.SRem(ctx, userSessionsKey(userID), sessionID)
+			continue
+		}
+
+		createdAt, _ := strconv.ParseInt(info["created_at"], 10, 64)
+		lastSeenAt, _ := strconv.ParseInt(info["last_seen_at"], 10, 64)
+		sessions = append(sessions, models.Session{
+			ID:         sessionID,
+			UserAgent:  info["user_agent"],
+			IP:         info["ip"],
+			CreatedAt:  time.Unix(createdAt, 0),
+			LastSeenAt: time.Unix(lastSeenAt, 0),
+			Current:    sessionID == currentSessionID,
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSession logs out a single session of the user
+func (a *AuthModule) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	isMember, err := a.redis.SIsMember(ctx, userSessionsKey(userID), sessionID).Result()
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		return ErrSessionNotFound
+	}
+	return a.deleteSession(ctx, userID, sessionID)
+}
+
+// RevokeOtherSessions logs out every session of the user except the kept one
+func (a *AuthModule) RevokeOtherSessions(ctx context.Context, userID int, keepSessionID string) error {
+	sessionIDs, err := a.redis.SMembers(ctx, userSessionsKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+	for _, sessionID := range sessionIDs {
+		if sessionID == keepSessionID {
+			continue
+		}
+		if err := a.deleteSession(ctx, userID, sessionID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeAllSessions logs out every session of the user
+func (a *AuthModule) RevokeAllSessions(ctx context.Context, userID int) error {
+	return a.RevokeOtherSessions(ctx, userID, "")
+}
+
+func (a *AuthModule) deleteSession(ctx context.Context, userID int, sessionID string) error {
+	key, err := a.redis.HGet(ctx, sessionInfoKey(sessionID), "key").Result()
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	pipe := a.redis.TxPipeline()
+	if key != "" {
+		pipe.Del(ctx, key)
+	}
+	pipe.Del(ctx, sessionInfoKey(sessionID))
+	pipe.SRem(ctx, userSessionsKey(userID), sessionID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,141 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"hash"
+	"sync"
+	"testing"
+	"time"
+)
+
+// RFC 6238 Appendix B, 8 digit codes with a 30 second period
+func TestHOTPMatchesRFC6238Vectors(t *testing.T) {
+	seeds := map[string]struct {
+		newHash func() hash.Hash
+		key     string
+	}{
+		"SHA1":   {sha1.New, "12345678901234567890"},
+		"SHA256": {sha256.New, "12345678901234567890123456789012"},
+		"SHA512": {sha512.New, "1234567890123456789012345678901234567890123456789012345678901234"},
+	}
+
+	tests := []struct {
+		unix      int64
+		algorithm string
+		want      string
+	}{
+		{59, "SHA1", "94287082"},
+		{59, "SHA256", "46119246"},
+		{59, "SHA512", "90693936"},
+		{1111111109, "SHA1", "07081804"},
+		{1111111109, "SHA256", "68084774"},
+		{1111111109, "SHA512", "25091201"},
+		{1111111111, "SHA1", "14050471"},
+		{1111111111, "SHA256", "67062674"},
+		{1111111111, "SHA512", "99943326"},
+		{1234567890, "SHA1", "89005924"},
+		{1234567890, "SHA256", "91819424"},
+		{1234567890, "SHA512", "93441116"},
+		{2000000000, "SHA1", "69279037"},
+		{2000000000, "SHA256", "90698825"},
+		{2000000000, "SHA512", "38618901"},
+		{20000000000, "SHA1", "65353130"},
+		{20000000000, "SHA256", "77737706"},
+		{20000000000, "SHA512", "47863826"},
+	}
+	for _, test := range tests {
+		seed := seeds[test.algorithm]
+		step := totpStep(time.Unix(test.unix, 0), 30*time.Second)
+		if got := hotp(seed.newHash, []byte(seed.key), step, 8); got != test.want {
+			t.Errorf("%s at %d = %s, want %s", test.algorithm, test.unix, got, test.want)
+		}
+	}
+}
+
+func TestMatchTOTPSkew(t *testing.T) {
+	key := []byte("12345678901234567890")
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now, totpPeriod)
+
+	for offset := -2; offset <= 2; offset++ {
+		code := hotp(sha1.New, key, uint64(int64(step)+int64(offset)), totpDigits)
+		matched, ok := matchTOTP(key, code, now)
+		wantOK := offset >= -totpSkew && offset <= totpSkew
+		if ok != wantOK {
+			t.Errorf("offset %d accepted = %v, want %v", offset, ok, wantOK)
+		}
+		if ok && matched != uint64(int64(step)+int64(offset)) {
+			t.Errorf("offset %d matched step %d", offset, matched)
+		}
+	}
+
+	if _, ok := matchTOTP(key, "1234567", now); ok {
+		t.Error("a code of the wrong length was accepted")
+	}
+}
+
+func TestDecodeTOTPSecret(t *testing.T) {
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := decodeTOTPSecret(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("decoded %d bytes, %v", len(key), err)
+	}
+
+	// Authenticator apps and users may show the secret in lower case or padded
+	for _, variant := range []string{"gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ===="} {
+		key, err := decodeTOTPSecret(variant)
+		if err != nil || string(key) != "12345678901234567890" {
+			t.Errorf("decode %q = %q, %v", variant, key, err)
+		}
+	}
+}
+
+func TestVerifyTOTPRejectsReplay(t *testing.T) {
+	a, _ := newTestAuthModule(t)
+	ctx := context.Background()
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := decodeTOTPSecret(secret)
+	code := hotp(sha1.New, key, totpStep(time.Now(), totpPeriod), totpDigits)
+
+	// Concurrent requests with the same code, exactly one may succeed
+	var wg sync.WaitGroup
+	results := make(chan error, 8)
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results <- a.verifyTOTP(ctx, 1, secret, code)
+		}()
+	}
+	wg.Wait()
+	close(results)
+
+	accepted := 0
+	for err := range results {
+		switch {
+		case err == nil:
+			accepted++
+		case !errors.Is(err, ErrInvalidTwoFactorCode):
+			t.Fatal(err)
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("code accepted %d times, want once", accepted)
+	}
+
+	// Steps are tracked per user
+	if err := a.verifyTOTP(ctx, 2, secret, code); err != nil {
+		t.Errorf("same code for another user: %v", err)
+	}
+}
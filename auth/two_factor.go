@@ -0,0 +1,281 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	twoFactorPendingTTL = 5 * time.Minute
+	// twoFactorMaxAttempts bounds code guesses per pending login
+	twoFactorMaxAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+var ErrTwoFactorRequired = errors.New("two-factor authentication required")
+
+var ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+
+var ErrInvalidPendingToken = errors.New("invalid or expired login attempt")
+
+var ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+
+var ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+
+var ErrTwoFactorNotEnrolled = errors.New("two-factor enrollment was not started")
+
+var ErrInvalidPassword = errors.New("invalid password")
+
+// TwoFactorEnrollment is shown once to the user to set up the authenticator app
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+func totpIssuer() string {
+	if value := os.Getenv("TOTP_ISSUER"); value != "" {
+		return value
+	}
+	return "InstagramPlus"
+}
+
+// EnrollTwoFactor creates a new secret for the user. It only takes effect once confirmed with a valid code.
+func (a *AuthModule) EnrollTwoFactor(ctx context.Context, userID int) (TwoFactorEnrollment, error) {
+	var username string
+	var enabled bool
+	err := a.db.QueryRow(ctx, "SELECT username, totp_enabled FROM users WHERE id = $1", userID).Scan(&username, &enabled)
+	if err != nil {
+		return TwoFactorEnrollment{}, errors.New("user not found")
+	}
+	if enabled {
+		return TwoFactorEnrollment{}, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+
+	_, err = a.db.Exec(ctx, "UPDATE users SET totp_secret = $1 WHERE id = $2", secret, userID)
+	if err != nil {
+		return TwoFactorEnrollment{}, err
+	}
+
+	return TwoFactorEnrollment{Secret: secret, URI: totpURI(totpIssuer(), username, secret)}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the user proves the app works, and returns the recovery codes
+func (a *AuthModule) ConfirmTwoFactor(ctx context.Context, userID int, code string) ([]string, error) {
+	var secret *string
+	var enabled bool
+	err := a.db.QueryRow(ctx, "SELECT totp_secret, totp_enabled FROM users WHERE id = $1", userID).Scan(&secret, &enabled)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+	if enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if secret == nil {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	if err := a.verifyTOTP(ctx, userID, *secret, code); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		randomBytes := make([]byte, 5)
+		if _, err := rand.Read(randomBytes); err != nil {
+			return nil, err
+		}
+		encoded := hex.EncodeToString(randomBytes)
+		codes[i] = encoded[:5] + "-" + encoded[5:]
+		hashes[i] = hashToken(codes[i])
+	}
+
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO recovery_codes (user_id, code_hash)
+		SELECT $1, UNNEST($2::text[])`, userID, hashes)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE users SET totp_enabled = TRUE WHERE id = $1", userID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off, it needs the password and a current or recovery code
+func (a *AuthModule) DisableTwoFactor(ctx context.Context, userID int, password, code string) error {
+	var passwordHash string
+	var secret *string
+	var enabled bool
+	err := a.db.QueryRow(ctx, "SELECT password, totp_secret, totp_enabled FROM users WHERE id = $1", userID).Scan(&passwordHash, &secret, &enabled)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if !enabled || secret == nil {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return ErrInvalidPassword
+	}
+	if err := a.verifySecondFactor(ctx, userID, *secret, code); err != nil {
+		return err
+	}
+
+	tx, err := a.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "UPDATE users SET totp_secret = NULL, totp_enabled = FALSE WHERE id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// startTwoFactorLogin parks a password-verified login until the second factor is provided
+func (a *AuthModule) startTwoFactorLogin(ctx context.Context, userID int) (string, error) {
+	token, err := generateSecureToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	key := "2fa_pending:" + hashToken(token)
+	pipe := a.redis.TxPipeline()
+	pipe.HSet(ctx, key, "user_id", userID, "attempts", 0)
+	pipe.Expire(ctx, key, twoFactorPendingTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CompleteTwoFactorLogin exchanges a pending login token and a valid code for a session
+func (a *AuthModule) CompleteTwoFactorLogin(ctx context.Context, pendingToken, code string, client ClientInfo) (int, string, error) {
+	key := "2fa_pending:" + hashToken(pendingToken)
+	userID, err := a.redis.HGet(ctx, key, "user_id").Int()
+	if err == redis.Nil {
+		return 0, "", ErrInvalidPendingToken
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	attempts, err := a.redis.HIncrBy(ctx, key, "attempts", 1).Result()
+	if err != nil {
+		return 0, "", err
+	}
+	if attempts > twoFactorMaxAttempts {
+		a.redis.Del(ctx, key)
+		return 0, "", ErrInvalidPendingToken
+	}
+
+	var username string
+	var secret *string
+	err = a.db.QueryRow(ctx, "SELECT username, totp_secret FROM users WHERE id = $1 AND totp_enabled", userID).Scan(&username, &secret)
+	if err != nil || secret == nil {
+		return 0, "", ErrInvalidPendingToken
+	}
+
+	// Wrong codes count towards the account lockout, new pending tokens do not give fresh guesses
+	if err := a.lockout.Check(ctx, username, client.IP); err != nil {
+		return 0, "", err
+	}
+	if err := a.verifySecondFactor(ctx, userID, *secret, code); err != nil {
+		if err == ErrInvalidTwoFactorCode {
+			if lockoutErr := a.lockout.RecordFailure(ctx, username, client.IP); lockoutErr != nil {
+				return 0, "", lockoutErr
+			}
+		}
+		return 0, "", err
+	}
+	if err := a.lockout.RecordSuccess(ctx, username); err != nil {
+		return 0, "", err
+	}
+
+	// The pending token is single use, a concurrent completion loses the race here
+	deleted, err := a.redis.Del(ctx, key).Result()
+	if err != nil {
+		return 0, "", err
+	}
+	if deleted == 0 {
+		return 0, "", ErrInvalidPendingToken
+	}
+
+	token, err := a.createSession(ctx, userID, client)
+	if err != nil {
+		return 0, "", err
+	}
+	return userID, token, nil
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code, which is consumed
+func (a *AuthModule) verifySecondFactor(ctx context.Context, userID int, secret, code string) error {
+	code = strings.TrimSpace(code)
+	if !strings.Contains(code, "-") {
+		return a.verifyTOTP(ctx, userID, secret, code)
+	}
+
+	tag, err := a.db.Exec(ctx, "DELETE FROM recovery_codes WHERE user_id = $1 AND code_hash = $2", userID, hashToken(strings.ToLower(code)))
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// verifyTOTP checks the code and rejects reuse of a time step that was already accepted.
+// The step is claimed with SET NX, so of two concurrent requests with the same code only one succeeds.
+func (a *AuthModule) verifyTOTP(ctx context.Context, userID int, secret, code string) error {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return err
+	}
+
+	step, ok := matchTOTP(key, code, time.Now())
+	if !ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	// A step is accepted for at most 2*totpSkew+1 periods, its marker does not need to outlive that
+	usedKey := "totp_used:" + strconv.Itoa(userID) + ":" + strconv.FormatUint(step, 10)
+	claimed, err := a.redis.SetNX(ctx, usedKey, 1, time.Duration(2*totpSkew+1)*totpPeriod).Result()
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package entities
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MaxHashtagLength bounds a single tag in characters, longer tags are ignored
+const MaxHashtagLength = 100
+
+// MaxHashtagsPerText bounds how many tags of a single text are indexed
+const MaxHashtagsPerText = 30
+
+// ExtractHashtags returns the distinct hashtags of a text in order of appearance, lowercased and without "#".
+// A tag starts after "#" that does not follow a word character and runs over letters, digits and "_".
+func ExtractHashtags(text string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '#' || (i > 0 && isWordRune(runes[i-1])) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		length := end - i - 1
+		tag := strings.ToLower(string(runes[i+1 : end]))
+		i = end - 1
+
+		// Purely numeric tags such as "#1" are usually not meant as tags
+		if length == 0 || length > MaxHashtagLength || strings.IndexFunc(tag, unicode.IsLetter) < 0 || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+		if len(tags) == MaxHashtagsPerText {
+			break
+		}
+	}
+	return tags
+}
+
+// NormalizeHashtag turns user input such as "#Travel" into the stored form
+func NormalizeHashtag(tag string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
+}
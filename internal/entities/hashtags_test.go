@@ -0,0 +1,88 @@
+package entities
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExtractHashtags(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"simple", "#Go is #fun", []string{"go", "fun"}},
+		{"order and duplicates", "#b #a #B #A", []string{"b", "a"}},
+		{"punctuation ends a tag", "#hello, #world! (#paren) #dot.", []string{"hello", "world", "paren", "dot"}},
+		{"underscores and digits", "#go_lang #web3", []string{"go_lang", "web3"}},
+		{"numeric tags", "#1 #2024 #42_ #2024goals", []string{"2024goals"}},
+		{"no letters", "#_ #__", []string{}},
+		{"inside a word", "a#b c#d", []string{}},
+		{"after a digit", "1#tag", []string{}},
+		{"double hash", "##double", []string{"double"}},
+		{"lone hash", "# #", []string{}},
+		{"combining mark in a tag", "#cafe\u0301 trip", []string{"cafe\u0301"}},
+		{"combining mark before the hash", "e\u0301#tag", []string{}},
+		{"non latin", "#नमस्ते #Привет", []string{"नमस्ते", "привет"}},
+		{"empty", "", []string{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ExtractHashtags(test.text); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ExtractHashtags(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractHashtagsLength(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{strings.Repeat("a", MaxHashtagLength), true},
+		{strings.Repeat("a", MaxHashtagLength+1), false},
+		// The limit counts characters, not bytes
+		{strings.Repeat("я", MaxHashtagLength), true},
+		{strings.Repeat("я", MaxHashtagLength+1), false},
+	}
+	for _, test := range tests {
+		got := ExtractHashtags("#" + test.tag + " #next")
+		want := []string{"next"}
+		if test.want {
+			want = []string{test.tag, "next"}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("tag of %d characters: got %d tags, want %d", len([]rune(test.tag)), len(got), len(want))
+		}
+	}
+}
+
+func TestExtractHashtagsLimit(t *testing.T) {
+	text := ""
+	for i := 0; i < MaxHashtagsPerText+5; i++ {
+		text += " #tag" + strconv.Itoa(i)
+	}
+
+	got := ExtractHashtags(text)
+	if len(got) != MaxHashtagsPerText {
+		t.Fatalf("extracted %d tags, want %d", len(got), MaxHashtagsPerText)
+	}
+	if got[0] != "tag0" || got[MaxHashtagsPerText-1] != "tag"+strconv.Itoa(MaxHashtagsPerText-1) {
+		t.Errorf("kept %q ... %q, want the first tags", got[0], got[len(got)-1])
+	}
+}
+
+func TestNormalizeHashtag(t *testing.T) {
+	for input, want := range map[string]string{
+		"#Travel":  "travel",
+		" travel ": "travel",
+		"#Привет":  "привет",
+	} {
+		if got := NormalizeHashtag(input); got != want {
+			t.Errorf("NormalizeHashtag(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
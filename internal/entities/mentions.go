@@ -0,0 +1,48 @@
+package entities
+
+import "strings"
+
+// MaxMentionsPerText bounds how many mentions of a single text are resolved
+const MaxMentionsPerText = 50
+
+// Mention is an "@username" in a text, Offset and Length count code points and include the "@"
+type Mention struct {
+	Username string
+	Offset   int
+	Length   int
+}
+
+// ExtractMentions returns the mentions of a text in order of appearance.
+// A mention starts after "@" that does not follow a word character, so email addresses are skipped,
+// and runs over ASCII letters, digits, "_" and "." with trailing dots left out.
+func ExtractMentions(text string) []Mention {
+	mentions := []Mention{}
+
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if runes[i] != '@' || (i > 0 && (isWordRune(runes[i-1]) || runes[i-1] == '@')) {
+			continue
+		}
+
+		end := i + 1
+		for end < len(runes) && isUsernameRune(runes[end]) {
+			end++
+		}
+		username := strings.TrimRight(string(runes[i+1:end]), ".")
+		start := i
+		i = end - 1
+
+		if username == "" {
+			continue
+		}
+		mentions = append(mentions, Mention{Username: username, Offset: start, Length: len(username) + 1})
+		if len(mentions) == MaxMentionsPerText {
+			break
+		}
+	}
+	return mentions
+}
+
+func isUsernameRune(r rune) bool {
+	return r == '_' || r == '.' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
@@ -0,0 +1,59 @@
+package entities
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestExtractMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []Mention
+	}{
+		{"simple", "hi @alice", []Mention{{"alice", 3, 6}}},
+		{"at the start", "@alice hi", []Mention{{"alice", 0, 6}}},
+		{"several", "@a and @b_c", []Mention{{"a", 0, 2}, {"b_c", 7, 4}}},
+		{"repeated mentions are kept", "@a @a", []Mention{{"a", 0, 2}, {"a", 3, 2}}},
+		{"dots inside", "@al.ice", []Mention{{"al.ice", 0, 7}}},
+		{"trailing dot", "thanks @bob.", []Mention{{"bob", 7, 4}}},
+		{"trailing dots", "@bob... ok", []Mention{{"bob", 0, 4}}},
+		{"punctuation", "(@bob), @carol!", []Mention{{"bob", 1, 4}, {"carol", 8, 6}}},
+		{"apostrophe", "@bob's post", []Mention{{"bob", 0, 4}}},
+		{"email", "mail a@b.com or x.y@example.org", []Mention{}},
+		{"double at", "@@bob", []Mention{}},
+		{"triple at", "@@@bob", []Mention{}},
+		{"lone at", "@ @. @_", []Mention{{"_", 5, 2}}},
+		{"after a dot", "@bob.@carol", []Mention{{"bob", 0, 4}, {"carol", 5, 6}}},
+		{"non ascii ends a username", "@bob\u00e9", []Mention{{"bob", 0, 4}}},
+		{"after a non ascii letter", "\u00e9@bob", []Mention{}},
+		// Offsets count code points, not bytes or UTF-16 units
+		{"offsets after multibyte text", "h\u00e9llo @Bob", []Mention{{"Bob", 6, 4}}},
+		{"offsets after an emoji", "\U0001F600 @bob", []Mention{{"bob", 2, 4}}},
+		{"offsets after a combining mark", "e\u0301 @bob", []Mention{{"bob", 3, 4}}},
+		{"empty", "", []Mention{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := ExtractMentions(test.text); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ExtractMentions(%q) = %+v, want %+v", test.text, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExtractMentionsLimit(t *testing.T) {
+	text := ""
+	for i := 0; i < MaxMentionsPerText+5; i++ {
+		text += "@user" + strconv.Itoa(i) + " "
+	}
+
+	got := ExtractMentions(text)
+	if len(got) != MaxMentionsPerText {
+		t.Fatalf("extracted %d mentions, want %d", len(got), MaxMentionsPerText)
+	}
+	if last := got[len(got)-1]; last.Username != "user"+strconv.Itoa(MaxMentionsPerText-1) {
+		t.Errorf("last mention = %+v, want the first mentions", last)
+	}
+}
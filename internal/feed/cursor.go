@@ -0,0 +1,64 @@
+package feed
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCursor = errors.New("invalid feed cursor")
+
+// Cursor is a position in a ranked feed. Every page is ranked at RankedAt, the time of the first page,
+// so the order stays put while the user scrolls. Score, Timestamp and ID belong to the last ranked post served.
+// Once the ranked candidates are exhausted the feed continues with older posts in chronological order,
+// Chronological is then set and Timestamp and ID point at the last of them.
+type Cursor struct {
+	RankedAt      time.Time
+	Chronological bool
+	Score         float64
+	Timestamp     time.Time
+	ID            int
+}
+
+func EncodeCursor(c Cursor) string {
+	kind := "r"
+	if c.Chronological {
+		kind = "c"
+	}
+	raw := strings.Join([]string{
+		kind,
+		strconv.FormatInt(c.RankedAt.UnixNano(), 10),
+		strconv.FormatFloat(c.Score, 'g', -1, 64),
+		strconv.FormatInt(c.Timestamp.UnixNano(), 10),
+		strconv.Itoa(c.ID),
+	}, ":")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(cursor string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	parts := strings.Split(string(raw), ":")
+	if len(parts) != 5 || (parts[0] != "r" && parts[0] != "c") {
+		return Cursor{}, ErrInvalidCursor
+	}
+
+	rankedAt, err1 := strconv.ParseInt(parts[1], 10, 64)
+	score, err2 := strconv.ParseFloat(parts[2], 64)
+	timestamp, err3 := strconv.ParseInt(parts[3], 10, 64)
+	id, err4 := strconv.Atoi(parts[4])
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return Cursor{
+		RankedAt:      time.Unix(0, rankedAt).UTC(),
+		Chronological: parts[0] == "c",
+		Score:         score,
+		Timestamp:     time.Unix(0, timestamp).UTC(),
+		ID:            id,
+	}, nil
+}
@@ -0,0 +1,151 @@
+package feed
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"time"
+
+	"instagramplusbackend/internal/models"
+)
+
+const (
+	// RecentCommentsWindow is how far back comments count towards Candidate.RecentComments
+	RecentCommentsWindow = 24 * time.Hour
+	// CandidateWindow and MaxCandidates bound the posts ranked together: the newest MaxCandidates posts
+	// of the CandidateWindow before the ranking time. Older posts follow in chronological order.
+	CandidateWindow = 72 * time.Hour
+	MaxCandidates   = 500
+)
+
+// Candidate is a post considered for the feed together with viewer-specific signals
+type Candidate struct {
+	Post           *models.Post
+	AuthorFollowed bool
+	RecentComments int
+}
+
+// Signal is the contribution of a single scorer, Reason is optional
+type Signal struct {
+	Score  float64
+	Reason string
+}
+
+type Scorer interface {
+	Name() string
+	Score(c Candidate, now time.Time) Signal
+}
+
+type Ranker struct {
+	scorers []Scorer
+	now     func() time.Time
+}
+
+func NewRanker(scorers ...Scorer) *Ranker {
+	return &Ranker{
+		scorers: scorers,
+		now:     time.Now,
+	}
+}
+
+// NewDeterministicRanker returns a ranker with a fixed clock, so the same input always produces the same order
+func NewDeterministicRanker(now time.Time, scorers ...Scorer) *Ranker {
+	return &Ranker{
+		scorers: scorers,
+		now:     func() time.Time { return now },
+	}
+}
+
+// NewRankerFromEnv uses the default scorers, FEED_FIXED_TIME (RFC 3339) enables the deterministic mode
+func NewRankerFromEnv() (*Ranker, error) {
+	if fixed := os.Getenv("FEED_FIXED_TIME"); fixed != "" {
+		now, err := time.Parse(time.RFC3339, fixed)
+		if err != nil {
+			return nil, errors.New("invalid FEED_FIXED_TIME value: " + err.Error())
+		}
+		return NewDeterministicRanker(now, DefaultScorers()...), nil
+	}
+	return NewRanker(DefaultScorers()...), nil
+}
+
+// Now is the ranking time of a new feed, later pages of the feed are ranked at the time of its first page
+func (r *Ranker) Now() time.Time {
+	return r.now()
+}
+
+// RecentCommentsRange is the window of comments counted as Candidate.RecentComments when ranking at the time,
+// queries pass it instead of using the database clock so the deterministic mode stays deterministic
+func RecentCommentsRange(at time.Time) (time.Time, time.Time) {
+	return at.Add(-RecentCommentsWindow), at
+}
+
+// CandidateRange is the creation time range of the posts ranked together at the time
+func CandidateRange(at time.Time) (time.Time, time.Time) {
+	return at.Add(-CandidateWindow), at
+}
+
+// Score scores the posts of the candidates as of the time and keeps their order
+func (r *Ranker) Score(candidates []Candidate, at time.Time) []models.Post {
+	posts := make([]models.Post, len(candidates))
+	for i, candidate := range candidates {
+		var total, best float64
+		reason := ""
+		for _, scorer := range r.scorers {
+			signal := scorer.Score(candidate, at)
+			total += signal.Score
+			if signal.Reason != "" && signal.Score > best {
+				best = signal.Score
+				reason = signal.Reason
+			}
+		}
+		posts[i] = *candidate.Post
+		posts[i].Score = total
+		posts[i].Reason = reason
+	}
+	return posts
+}
+
+// Rank scores the candidates as of the time and orders their posts by score, ties are broken by recency and id
+func (r *Ranker) Rank(candidates []Candidate, at time.Time) []models.Post {
+	posts := r.Score(candidates, at)
+	sort.SliceStable(posts, func(i, j int) bool {
+		return positionOf(posts[i]).before(positionOf(posts[j]))
+	})
+	return posts
+}
+
+// Page ranks the candidates as of the time and returns up to limit posts following the position of the cursor,
+// or the first ones without a cursor. more reports whether ranked posts are left after the page.
+func (r *Ranker) Page(candidates []Candidate, at time.Time, after *Cursor, limit int) (posts []models.Post, more bool) {
+	ranked := r.Rank(candidates, at)
+	start := 0
+	if after != nil {
+		last := position{score: after.Score, created: after.Timestamp, id: after.ID}
+		start = sort.Search(len(ranked), func(i int) bool {
+			return last.before(positionOf(ranked[i]))
+		})
+	}
+	end := min(start+limit, len(ranked))
+	return ranked[start:end], end < len(ranked)
+}
+
+// position is the place of a post in the ranked order: by score, then recency, then id
+type position struct {
+	score   float64
+	created time.Time
+	id      int
+}
+
+func positionOf(post models.Post) position {
+	return position{score: post.Score, created: post.CreationTimestamp, id: post.ID}
+}
+
+func (a position) before(b position) bool {
+	if a.score != b.score {
+		return a.score > b.score
+	}
+	if !a.created.Equal(b.created) {
+		return a.created.After(b.created)
+	}
+	return a.id > b.id
+}
@@ -0,0 +1,238 @@
+package feed
+
+import (
+	"testing"
+	"time"
+
+	"instagramplusbackend/internal/models"
+)
+
+var testNow = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+func candidate(id int, age time.Duration, likes, recentComments int, followed bool) Candidate {
+	return Candidate{
+		Post: &models.Post{
+			ID:                id,
+			CreationTimestamp: testNow.Add(-age),
+			LikesCount:        likes,
+		},
+		AuthorFollowed: followed,
+		RecentComments: recentComments,
+	}
+}
+
+func ids(posts []models.Post) []int {
+	result := make([]int, len(posts))
+	for i, post := range posts {
+		result[i] = post.ID
+	}
+	return result
+}
+
+func equalIDs(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRankOrdersByScore(t *testing.T) {
+	ranker := NewDeterministicRanker(testNow, DefaultScorers()...)
+
+	posts := ranker.Rank([]Candidate{
+		candidate(1, time.Hour, 0, 0, false),
+		candidate(2, 2*time.Hour, 500, 0, false),
+		candidate(3, 3*time.Hour, 0, 0, true),
+		candidate(4, 72*time.Hour, 0, 0, false),
+	}, testNow)
+
+	if got, want := ids(posts), []int{2, 3, 1, 4}; !equalIDs(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+	if posts[0].Reason != ReasonPopular {
+		t.Errorf("reason of popular post = %q, want %q", posts[0].Reason, ReasonPopular)
+	}
+	if posts[1].Reason != ReasonFollowed {
+		t.Errorf("reason of followed post = %q, want %q", posts[1].Reason, ReasonFollowed)
+	}
+	if posts[2].Reason != "" {
+		t.Errorf("reason of plain post = %q, want none", posts[2].Reason)
+	}
+}
+
+func TestRankBreaksTiesByRecencyAndID(t *testing.T) {
+	ranker := NewDeterministicRanker(testNow)
+
+	posts := ranker.Rank([]Candidate{
+		candidate(1, time.Hour, 0, 0, false),
+		candidate(3, 2*time.Hour, 0, 0, false),
+		candidate(2, time.Hour, 0, 0, false),
+	}, testNow)
+
+	if got, want := ids(posts), []int{2, 1, 3}; !equalIDs(got, want) {
+		t.Fatalf("order = %v, want %v", got, want)
+	}
+}
+
+func TestDeterministicRankerIsStable(t *testing.T) {
+	build := func() []Candidate {
+		return []Candidate{
+			candidate(1, 30*time.Minute, 10, 2, false),
+			candidate(2, 5*time.Hour, 80, 12, true),
+			candidate(3, 20*time.Hour, 3, 0, true),
+			candidate(4, 2*time.Hour, 60, 1, false),
+		}
+	}
+
+	first := NewDeterministicRanker(testNow, DefaultScorers()...).Rank(build(), testNow)
+	second := NewDeterministicRanker(testNow, DefaultScorers()...).Rank(build(), testNow)
+	if !equalIDs(ids(first), ids(second)) {
+		t.Fatalf("orders differ: %v and %v", ids(first), ids(second))
+	}
+	for i := range first {
+		if first[i].Score != second[i].Score {
+			t.Errorf("post %d scored %v and %v", first[i].ID, first[i].Score, second[i].Score)
+		}
+	}
+}
+
+func TestPageFollowsRankedOrder(t *testing.T) {
+	ranker := NewDeterministicRanker(testNow, DefaultScorers()...)
+	candidates := []Candidate{
+		candidate(1, time.Hour, 0, 0, false),
+		candidate(2, 2*time.Hour, 500, 0, false),
+		candidate(3, 3*time.Hour, 0, 0, true),
+		candidate(4, 48*time.Hour, 0, 0, false),
+		candidate(5, 50*time.Hour, 0, 0, false),
+	}
+	want := ids(ranker.Rank(candidates, testNow))
+
+	var got []int
+	var after *Cursor
+	for pages := 0; ; pages++ {
+		if pages > len(candidates) {
+			t.Fatal("paging does not end")
+		}
+		posts, more := ranker.Page(candidates, testNow, after, 2)
+		got = append(got, ids(posts)...)
+		if !more {
+			break
+		}
+		last := posts[len(posts)-1]
+		after = &Cursor{RankedAt: testNow, Score: last.Score, Timestamp: last.CreationTimestamp, ID: last.ID}
+	}
+	if !equalIDs(got, want) {
+		t.Fatalf("pages = %v, want %v", got, want)
+	}
+}
+
+func TestPageAfterVanishedPost(t *testing.T) {
+	ranker := NewDeterministicRanker(testNow, DefaultScorers()...)
+	candidates := []Candidate{
+		candidate(1, time.Hour, 0, 0, false),
+		candidate(2, 2*time.Hour, 500, 0, false),
+		candidate(3, 3*time.Hour, 0, 0, true),
+	}
+	ranked := ranker.Rank(candidates, testNow)
+
+	// The post the cursor points at was deleted since, the page starts at the next position
+	last := ranked[0]
+	after := &Cursor{RankedAt: testNow, Score: last.Score, Timestamp: last.CreationTimestamp, ID: last.ID}
+	posts, more := ranker.Page(candidates[:1], testNow, after, 10)
+	if more || !equalIDs(ids(posts), []int{1}) {
+		t.Fatalf("page = %v (more %v), want [1]", ids(posts), more)
+	}
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+	tests := []Cursor{
+		{RankedAt: testNow, Score: 3.0000000000000004, Timestamp: testNow.Add(-time.Hour + time.Microsecond), ID: 42},
+		{RankedAt: testNow, Chronological: true, Timestamp: testNow.Add(-CandidateWindow), ID: 0},
+	}
+	for _, want := range tests {
+		got, err := DecodeCursor(EncodeCursor(want))
+		if err != nil {
+			t.Fatalf("%+v: %v", want, err)
+		}
+		if got != want {
+			t.Errorf("decoded %+v, want %+v", got, want)
+		}
+	}
+
+	for _, invalid := range []string{"not base64!", "eDox", EncodeCursor(Cursor{})[:4]} {
+		if _, err := DecodeCursor(invalid); err != ErrInvalidCursor {
+			t.Errorf("DecodeCursor(%q) error = %v, want %v", invalid, err, ErrInvalidCursor)
+		}
+	}
+}
+
+func TestRecencyScorerHalvesPerHalfLife(t *testing.T) {
+	scorer := RecencyScorer{Weight: 4, HalfLife: 24 * time.Hour}
+
+	tests := []struct {
+		age  time.Duration
+		want float64
+	}{
+		{0, 4},
+		{24 * time.Hour, 2},
+		{48 * time.Hour, 1},
+		// Posts from the future of the clock count as new
+		{-time.Hour, 4},
+	}
+	for _, test := range tests {
+		got := scorer.Score(candidate(1, test.age, 0, 0, false), testNow).Score
+		if got != test.want {
+			t.Errorf("score at age %v = %v, want %v", test.age, got, test.want)
+		}
+	}
+}
+
+func TestCommentsScorerReason(t *testing.T) {
+	scorer := CommentsScorer{Weight: 1.5, ActiveThreshold: 10}
+
+	if signal := scorer.Score(candidate(1, 0, 0, 9, false), testNow); signal.Reason != "" {
+		t.Errorf("reason below threshold = %q, want none", signal.Reason)
+	}
+	if signal := scorer.Score(candidate(1, 0, 0, 10, false), testNow); signal.Reason != ReasonActive {
+		t.Errorf("reason at threshold = %q, want %q", signal.Reason, ReasonActive)
+	}
+	if signal := scorer.Score(candidate(1, 0, 0, 0, false), testNow); signal.Score != 0 {
+		t.Errorf("score without comments = %v, want 0", signal.Score)
+	}
+}
+
+func TestRecentCommentsRange(t *testing.T) {
+	since, until := RecentCommentsRange(testNow)
+	if !until.Equal(testNow) {
+		t.Errorf("until = %v, want %v", until, testNow)
+	}
+	if !since.Equal(testNow.Add(-RecentCommentsWindow)) {
+		t.Errorf("since = %v, want %v", since, testNow.Add(-RecentCommentsWindow))
+	}
+}
+
+func TestNewRankerFromEnv(t *testing.T) {
+	t.Setenv("FEED_FIXED_TIME", "2025-03-01T12:00:00Z")
+	ranker, err := NewRankerFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if now := ranker.Now(); !now.Equal(testNow) {
+		t.Errorf("clock = %v, want %v", now, testNow)
+	}
+
+	t.Setenv("FEED_FIXED_TIME", "yesterday")
+	if _, err := NewRankerFromEnv(); err == nil {
+		t.Error("invalid FEED_FIXED_TIME was accepted")
+	}
+
+	t.Setenv("FEED_FIXED_TIME", "")
+	if _, err := NewRankerFromEnv(); err != nil {
+		t.Errorf("default ranker: %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package feed
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	ReasonFollowed = "from someone you follow"
+	ReasonPopular  = "popular"
+	ReasonActive   = "active discussion"
+)
+
+// FollowScorer boosts posts from authors the viewer follows
+type FollowScorer struct {
+	Weight float64
+}
+
+func (s FollowScorer) Name() string { return "follow" }
+
+func (s FollowScorer) Score(c Candidate, now time.Time) Signal {
+	if !c.AuthorFollowed {
+		return Signal{}
+	}
+	return Signal{Score: s.Weight, Reason: ReasonFollowed}
+}
+
+// LikesScorer rewards posts with many likes, on a logarithmic scale
+type LikesScorer struct {
+	Weight           float64
+	PopularThreshold int
+}
+
+func (s LikesScorer) Name() string { return "likes" }
+
+func (s LikesScorer) Score(c Candidate, now time.Time) Signal {
+	signal := Signal{Score: s.Weight * math.Log1p(float64(c.Post.LikesCount))}
+	if c.Post.LikesCount >= s.PopularThreshold {
+		signal.Reason = ReasonPopular
+	}
+	return signal
+}
+
+// CommentsScorer rewards posts with recent comment activity
+type CommentsScorer struct {
+	Weight          float64
+	ActiveThreshold int
+}
+
+func (s CommentsScorer) Name() string { return "comments" }
+
+func (s CommentsScorer) Score(c Candidate, now time.Time) Signal {
+	signal := Signal{Score: s.Weight * math.Log1p(float64(c.RecentComments))}
+	if c.RecentComments >= s.ActiveThreshold {
+		signal.Reason = ReasonActive
+	}
+	return signal
+}
+
+// RecencyScorer decays exponentially with post age
+type RecencyScorer struct {
+	Weight   float64
+	HalfLife time.Duration
+}
+
+func (s RecencyScorer) Name() string { return "recency" }
+
+func (s RecencyScorer) Score(c Candidate, now time.Time) Signal {
+	age := max(now.Sub(c.Post.CreationTimestamp), 0)
+	return Signal{Score: s.Weight * math.Exp2(-age.Hours()/s.HalfLife.Hours())}
+}
+
+func DefaultScorers() []Scorer {
+	return []Scorer{
+		FollowScorer{Weight: 3},
+		LikesScorer{Weight: 1, PopularThreshold: 50},
+		CommentsScorer{Weight: 1.5, ActiveThreshold: 10},
+		RecencyScorer{Weight: 4, HalfLife: 24 * time.Hour},
+	}
+}
@@ -0,0 +1,110 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// withOrientation inserts an APP1 Exif segment holding only the orientation tag right after the SOI marker
+func withOrientation(jpegData []byte, order binary.AppendByteOrder, orientation int) []byte {
+	tiff := []byte("II*\x00")
+	if order == binary.BigEndian {
+		tiff = []byte("MM\x00*")
+	}
+	tiff = order.AppendUint32(tiff, 8)
+	tiff = order.AppendUint16(tiff, 1)
+	// Tag, type SHORT, count, value padded to four bytes
+	tiff = order.AppendUint16(tiff, 0x0112)
+	tiff = order.AppendUint16(tiff, 3)
+	tiff = order.AppendUint32(tiff, 1)
+	tiff = order.AppendUint16(tiff, uint16(orientation))
+	tiff = append(tiff, 0, 0)
+	tiff = order.AppendUint32(tiff, 0)
+
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	app1 := []byte{0xFF, 0xE1}
+	app1 = binary.BigEndian.AppendUint16(app1, uint16(len(segment)+2))
+	app1 = append(app1, segment...)
+
+	data := append([]byte{}, jpegData[:2]...)
+	data = append(data, app1...)
+	return append(data, jpegData[2:]...)
+}
+
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExifOrientation(t *testing.T) {
+	plain := encodeJPEG(t, 4, 4)
+	truncated := withOrientation(plain, binary.LittleEndian, 6)[:20]
+
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"no exif", plain, 1},
+		{"little endian", withOrientation(plain, binary.LittleEndian, 6), 6},
+		{"big endian", withOrientation(plain, binary.BigEndian, 3), 3},
+		{"normal", withOrientation(plain, binary.BigEndian, 1), 1},
+		{"out of range", withOrientation(plain, binary.LittleEndian, 9), 1},
+		{"zero", withOrientation(plain, binary.LittleEndian, 0), 1},
+		{"truncated segment", truncated, 1},
+		{"not a jpeg", []byte("GIF89a"), 1},
+		{"empty", nil, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := exifOrientation(test.data); got != test.want {
+				t.Errorf("exifOrientation = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 3x2 image with a marked top-left and top-right pixel
+	topLeft := color.RGBA{255, 0, 0, 255}
+	topRight := color.RGBA{0, 0, 255, 255}
+	src := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	src.SetRGBA(0, 0, topLeft)
+	src.SetRGBA(2, 0, topRight)
+
+	tests := []struct {
+		orientation       int
+		width, height     int
+		topLeft, topRight image.Point
+	}{
+		{1, 3, 2, image.Pt(0, 0), image.Pt(2, 0)},
+		{2, 3, 2, image.Pt(2, 0), image.Pt(0, 0)},
+		{3, 3, 2, image.Pt(2, 1), image.Pt(0, 1)},
+		{4, 3, 2, image.Pt(0, 1), image.Pt(2, 1)},
+		{5, 2, 3, image.Pt(0, 0), image.Pt(0, 2)},
+		{6, 2, 3, image.Pt(1, 0), image.Pt(1, 2)},
+		{7, 2, 3, image.Pt(1, 2), image.Pt(1, 0)},
+		{8, 2, 3, image.Pt(0, 2), image.Pt(0, 0)},
+	}
+	for _, test := range tests {
+		got := applyOrientation(src, test.orientation)
+		if got.Bounds().Dx() != test.width || got.Bounds().Dy() != test.height {
+			t.Errorf("orientation %d: size %v, want %dx%d", test.orientation, got.Bounds().Size(), test.width, test.height)
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(test.topLeft.X, test.topLeft.Y)); c != topLeft {
+			t.Errorf("orientation %d: pixel at %v = %v, want the top-left pixel", test.orientation, test.topLeft, c)
+		}
+		if c := color.RGBAModel.Convert(got.At(test.topRight.X, test.topRight.Y)); c != topRight {
+			t.Errorf("orientation %d: pixel at %v = %v, want the top-right pixel", test.orientation, test.topRight, c)
+		}
+	}
+}
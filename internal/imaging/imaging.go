@@ -0,0 +1,169 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+const (
+	// MaxPixels rejects decompression bombs before the full image is decoded
+	MaxPixels = 40_000_000
+	// jpegQuality is used for every rendition
+	jpegQuality = 85
+)
+
+var ErrUnsupportedFormat = errors.New("unsupported image format")
+
+var ErrImageTooLarge = errors.New("image is too large")
+
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatGIF  = "gif"
+)
+
+// Rendition describes one sized output of the pipeline
+type Rendition struct {
+	Name string
+	// MaxSize bounds the longer side, the image is never upscaled
+	MaxSize int
+	// Square crops the center of the image before resizing
+	Square bool
+}
+
+var (
+	Thumbnail = Rendition{Name: "thumb", MaxSize: 150, Square: true}
+	Feed      = Rendition{Name: "feed", MaxSize: 640}
+	Full      = Rendition{Name: "full", MaxSize: 1080}
+)
+
+var DefaultRenditions = []Rendition{Thumbnail, Feed, Full}
+
+// Output is an encoded JPEG rendition
+type Output struct {
+	Rendition Rendition
+	Data      []byte
+	Width     int
+	Height    int
+}
+
+// DetectFormat identifies the image type from its magic bytes, ignoring file names and client content types
+func DetectFormat(header []byte) (string, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte{0xFF, 0xD8, 0xFF}):
+		return FormatJPEG, nil
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1a\n")):
+		return FormatPNG, nil
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return FormatGIF, nil
+	default:
+		return "", ErrUnsupportedFormat
+	}
+}
+
+// Process validates and decodes an uploaded image, applies the EXIF orientation and re-encodes every rendition as JPEG.
+// Re-encoding drops all metadata, including EXIF and GPS data.
+func Process(r io.Reader, renditions []Rendition) ([]Output, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	format, err := DetectFormat(data)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := decodeConfig(format, data)
+	if err != nil {
+		return nil, err
+	}
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrImageTooLarge
+	}
+
+	img, err := decode(format, data)
+	if err != nil {
+		return nil, err
+	}
+	if format == FormatJPEG {
+		img = applyOrientation(img, exifOrientation(data))
+	}
+
+	// Flatten transparency onto white, JPEG has no alpha channel
+	bounds := img.Bounds()
+	flat := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(flat, flat.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, bounds.Min, draw.Over)
+
+	outputs := []Output{}
+	for _, rendition := range renditions {
+		resized := render(flat, rendition)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, resized, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, err
+		}
+		outputs = append(outputs, Output{
+			Rendition: rendition,
+			Data:      buf.Bytes(),
+			Width:     resized.Bounds().Dx(),
+			Height:    resized.Bounds().Dy(),
+		})
+	}
+
+	return outputs, nil
+}
+
+func decodeConfig(format string, data []byte) (image.Config, error) {
+	switch format {
+	case FormatJPEG:
+		return jpeg.DecodeConfig(bytes.NewReader(data))
+	case FormatPNG:
+		return png.DecodeConfig(bytes.NewReader(data))
+	default:
+		return gif.DecodeConfig(bytes.NewReader(data))
+	}
+}
+
+// decode uses the decoder matching the detected format, animated GIFs keep their first frame
+func decode(format string, data []byte) (image.Image, error) {
+	switch format {
+	case FormatJPEG:
+		return jpeg.Decode(bytes.NewReader(data))
+	case FormatPNG:
+		return png.Decode(bytes.NewReader(data))
+	default:
+		return gif.Decode(bytes.NewReader(data))
+	}
+}
+
+func render(src *image.RGBA, rendition Rendition) *image.RGBA {
+	if rendition.Square {
+		src = cropSquare(src)
+	}
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	longer := max(width, height)
+	if longer <= rendition.MaxSize {
+		return src
+	}
+
+	scale := float64(rendition.MaxSize) / float64(longer)
+	return resize(src, max(int(float64(width)*scale+0.5), 1), max(int(float64(height)*scale+0.5), 1))
+}
+
+func cropSquare(src *image.RGBA) *image.RGBA {
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	size := min(width, height)
+	x0 := (width - size) / 2
+	y0 := (height - size) / 2
+	return src.SubImage(image.Rect(x0, y0, x0+size, y0+size)).(*image.RGBA)
+}
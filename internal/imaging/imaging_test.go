@@ -0,0 +1,117 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr error
+	}{
+		{"jpeg", []byte{0xFF, 0xD8, 0xFF, 0xE0}, FormatJPEG, nil},
+		{"png", []byte("\x89PNG\r\n\x1a\n\x00"), FormatPNG, nil},
+		{"gif87a", []byte("GIF87a"), FormatGIF, nil},
+		{"gif89a", []byte("GIF89a"), FormatGIF, nil},
+		{"webp", []byte("RIFF\x00\x00\x00\x00WEBP"), "", ErrUnsupportedFormat},
+		{"svg", []byte("<svg xmlns="), "", ErrUnsupportedFormat},
+		{"truncated jpeg", []byte{0xFF, 0xD8}, "", ErrUnsupportedFormat},
+		{"empty", nil, "", ErrUnsupportedFormat},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DetectFormat(test.header)
+			if got != test.want || err != test.wantErr {
+				t.Errorf("DetectFormat = %q, %v, want %q, %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+// gifHeader is a GIF with a logical screen of the given size and no image data
+func gifHeader(width, height int) []byte {
+	data := []byte("GIF89a")
+	data = binary.LittleEndian.AppendUint16(data, uint16(width))
+	data = binary.LittleEndian.AppendUint16(data, uint16(height))
+	return append(data, 0, 0, 0)
+}
+
+func TestProcessMaxPixels(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		wantTooLarge  bool
+	}{
+		{"over the limit", 10_000, 4_001, true},
+		{"largest dimensions", 65_535, 65_535, true},
+		// Within the limit the header passes and decoding fails on the missing image data
+		{"at the limit", 8_000, 5_000, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Process(bytes.NewReader(gifHeader(test.width, test.height)), DefaultRenditions)
+			if err == nil {
+				t.Fatal("Process accepted a GIF without image data")
+			}
+			if (err == ErrImageTooLarge) != test.wantTooLarge {
+				t.Errorf("Process error = %v, want ErrImageTooLarge %v", err, test.wantTooLarge)
+			}
+		})
+	}
+}
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessRenditionSizes(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        []byte
+		thumb, feed image.Point
+		full        image.Point
+	}{
+		{"landscape", encodePNG(t, 2000, 1000), image.Pt(150, 150), image.Pt(640, 320), image.Pt(1080, 540)},
+		{"portrait", encodePNG(t, 1000, 3000), image.Pt(150, 150), image.Pt(213, 640), image.Pt(360, 1080)},
+		// Small images are never upscaled
+		{"small", encodePNG(t, 100, 50), image.Pt(50, 50), image.Pt(100, 50), image.Pt(100, 50)},
+		// A 40x20 image rotated 90 degrees clockwise is displayed as 20x40
+		{"exif rotated", withOrientation(encodeJPEG(t, 40, 20), binary.LittleEndian, 6), image.Pt(20, 20), image.Pt(20, 40), image.Pt(20, 40)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			outputs, err := Process(bytes.NewReader(test.data), DefaultRenditions)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(outputs) != len(DefaultRenditions) {
+				t.Fatalf("got %d outputs, want %d", len(outputs), len(DefaultRenditions))
+			}
+
+			want := map[string]image.Point{Thumbnail.Name: test.thumb, Feed.Name: test.feed, Full.Name: test.full}
+			for _, output := range outputs {
+				if got := image.Pt(output.Width, output.Height); got != want[output.Rendition.Name] {
+					t.Errorf("%s rendition is %v, want %v", output.Rendition.Name, got, want[output.Rendition.Name])
+				}
+				config, err := jpeg.DecodeConfig(bytes.NewReader(output.Data))
+				if err != nil {
+					t.Errorf("%s rendition is not a JPEG: %v", output.Rendition.Name, err)
+				} else if config.Width != output.Width || config.Height != output.Height {
+					t.Errorf("%s rendition encodes %dx%d, reports %dx%d", output.Rendition.Name, config.Width, config.Height, output.Width, output.Height)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers transactional emails such as password resets
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// NewFromEnv builds the mailer selected by MAILER, "smtp" or "memory".
+// There is no default, an unset MAILER would otherwise silently drop password resets and verification emails.
+func NewFromEnv() (Mailer, error) {
+	switch os.Getenv("MAILER") {
+	case "":
+		return nil, errors.New("MAILER is not set, use \"smtp\" or \"memory\" for development")
+	case "memory":
+		return NewMemoryMailer(), nil
+	case "smtp":
+		port, err := strconv.Atoi(envOr("SMTP_PORT", "587"))
+		if err != nil {
+			return nil, errors.New("invalid SMTP_PORT value: " + err.Error())
+		}
+		return NewSMTPMailer(SMTPConfig{
+			Host:     os.Getenv("SMTP_HOST"),
+			Port:     port,
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+			From:     os.Getenv("SMTP_FROM"),
+		})
+	default:
+		return nil, errors.New("unknown MAILER: " + os.Getenv("MAILER"))
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
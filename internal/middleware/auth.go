@@ -1,16 +1,41 @@
 package middleware
 
 import (
+	"instagramplusbackend/auth"
 	"instagramplusbackend/internal/utils"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
 )
 
+// RequireAuth accepts the AUTH session cookie or an API token in the Authorization header.
+// Requests with an API token are limited to its scopes, reads need "read" and everything else "post".
 func (m *MiddlewareManager) RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if bearer, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found && auth.IsAPIToken(bearer) {
+			userID, scopes, err := m.auth.ValidateAPIToken(c.Request.Context(), bearer)
+			if err != nil {
+				if err != auth.ErrInvalidToken {
+					utils.LogError(c, err)
+				}
+				c.AbortWithStatus(http.StatusUnauthorized)
+				return
+			}
+
+			if !auth.HasScope(scopes, defaultScope(c.Request.Method)) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient token scope"})
+				return
+			}
+
+			c.Set("user_id", userID)
+			c.Set("token_scopes", scopes)
+			c.Next()
+			return
+		}
+
 		token, err := c.Cookie("AUTH")
 		if err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
@@ -31,16 +56,55 @@ func (m *MiddlewareManager) RequireAuth() gin.HandlerFunc {
 			return
 		}
 		c.Set("user_id", userIDInt)
+		c.Set("session_id", auth.SessionID(token))
 
 		c.Next()
 	}
 }
 
+func defaultScope(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return auth.ScopeRead
+	default:
+		return auth.ScopePost
+	}
+}
+
+// RequireScope rejects API tokens without the scope, cookie sessions are not limited by scopes.
+// It has to run after RequireAuth.
+func (m *MiddlewareManager) RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, usesToken := c.Get("token_scopes")
+		if usesToken && !auth.HasScope(scopes.([]string), scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient token scope"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSession rejects API tokens, it guards account settings such as tokens, sessions and passwords.
+// It has to run after RequireAuth.
+func (m *MiddlewareManager) RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, usesToken := c.Get("token_scopes"); usesToken {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "this action requires logging in"})
+			return
+		}
+		c.Next()
+	}
+}
+
 func (m *MiddlewareManager) isUserAdmin(c *gin.Context) (bool, error) {
 	userID, exists := c.Get("user_id")
 	if !exists {
 		return false, nil
 	}
+	// Admin rights through an API token need the admin scope
+	if scopes, usesToken := c.Get("token_scopes"); usesToken && !auth.HasScope(scopes.([]string), auth.ScopeAdmin) {
+		return false, nil
+	}
 	var isAdmin bool
 	err := m.pgClient.QueryRow(c.Request.Context(), "SELECT is_admin FROM users WHERE id = $1", userID).Scan(&isAdmin)
 	if err != nil {
@@ -1,6 +1,10 @@
 package middleware
 
 import (
+	"context"
+	"os"
+	"strings"
+
 	"instagramplusbackend/auth"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -11,12 +15,30 @@ type MiddlewareManager struct {
 	pgClient    *pgxpool.Pool
 	redisClient *redis.Client
 	auth        *auth.AuthModule
+	// verifiedActions lists the actions that require a verified email
+	verifiedActions map[string]bool
+	// isPremium looks up whether a user is premium, tests replace the database query
+	isPremium func(ctx context.Context, userID int) (bool, error)
+}
+
+func NewMiddlewareManager(pgClient *pgxpool.Pool, redisClient *redis.Client, authModule *auth.AuthModule) *MiddlewareManager {
+	m := &MiddlewareManager{
+		pgClient:        pgClient,
+		redisClient:     redisClient,
+		auth:            authModule,
+		verifiedActions: parseActionList(os.Getenv("EMAIL_VERIFICATION_REQUIRED")),
+	}
+	m.isPremium = m.queryPremium
+	return m
 }
 
-func NewMiddlewareManager(pgClient *pgxpool.Pool, redisClient *redis.Client) *MiddlewareManager {
-	return &MiddlewareManager{
-		pgClient:    pgClient,
-		redisClient: redisClient,
-		auth:        auth.NewAuthModule(pgClient, redisClient),
+// parseActionList reads a comma separated list such as "post,comment", "all" enables every action
+func parseActionList(value string) map[string]bool {
+	actions := map[string]bool{}
+	for _, action := range strings.Split(value, ",") {
+		if action = strings.TrimSpace(action); action != "" {
+			actions[action] = true
+		}
 	}
+	return actions
 }
@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"context"
+	"instagramplusbackend/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// RateLimitPolicy is a token bucket of Limit requests that refills completely over Period
+type RateLimitPolicy struct {
+	// Name separates the buckets of different policies
+	Name   string
+	Limit  int
+	Period time.Duration
+	// PremiumLimit replaces Limit for premium users when set
+	PremiumLimit int
+}
+
+// tokenBucketScript takes one token from the bucket in KEYS[1] using the Redis clock.
+// It returns whether the request is allowed, the remaining tokens, the milliseconds until
+// a token is available and the milliseconds until the bucket is full again.
+var tokenBucketScript = redis.NewScript(`
+local capacity = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated_at')
+local tokens = tonumber(bucket[1])
+local updated = tonumber(bucket[2])
+if tokens == nil or updated == nil then
+	tokens = capacity
+	updated = now
+end
+
+local rate = capacity / period
+tokens = math.min(capacity, tokens + math.max(0, now - updated) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated_at', now)
+redis.call('PEXPIRE', KEYS[1], period)
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+
+// RateLimit limits requests per user, or per client address before authentication.
+// It sets the X-RateLimit-* headers and answers 429 with Retry-After once the bucket is empty.
+// Redis failures let requests through, an outage of the limiter should not take the API down.
+func (m *MiddlewareManager) RateLimit(policy RateLimitPolicy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limit := policy.Limit
+		key := "ratelimit:" + policy.Name + ":ip:" + c.ClientIP()
+		if userID, exists := c.Get("user_id"); exists {
+			key = "ratelimit:" + policy.Name + ":user:" + strconv.Itoa(userID.(int))
+
+			if policy.PremiumLimit > 0 {
+				isPremium, err := m.isPremium(c.Request.Context(), userID.(int))
+				if err != nil {
+					utils.LogError(c, err)
+				} else if isPremium {
+					limit = policy.PremiumLimit
+				}
+			}
+		}
+
+		result, err := tokenBucketScript.Run(c.Request.Context(), m.redisClient, []string{key}, limit, policy.Period.Milliseconds()).Int64Slice()
+		if err != nil {
+			utils.LogError(c, err)
+			c.Next()
+			return
+		}
+		allowed, remaining, retryAfter, resetAfter := result[0] == 1, result[1], time.Duration(result[2])*time.Millisecond, time.Duration(result[3])*time.Millisecond
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.FormatInt(remaining, 10))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(time.Now().Add(resetAfter).Unix(), 10))
+
+		if !allowed {
+			c.Header("Retry-After", strconv.FormatInt(int64((retryAfter+time.Second-1)/time.Second), 10))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "rate limit exceeded"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func (m *MiddlewareManager) queryPremium(ctx context.Context, userID int) (bool, error) {
+	var isPremium bool
+	err := m.pgClient.QueryRow(ctx, "SELECT is_premium FROM users WHERE id = $1", userID).Scan(&isPremium)
+	if err != nil {
+		return false, err
+	}
+	return isPremium, nil
+}
@@ -0,0 +1,160 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+var testRateLimitPolicy = RateLimitPolicy{Name: "test", Limit: 3, Period: time.Minute, PremiumLimit: 6}
+
+// testNow is the Redis clock of the tests, the buckets refill by the script reading TIME
+var testNow = time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newTestRateLimiter serves the policy on "/" to the user in the X-Test-User header, or anonymously without it.
+// Users in premium are premium, the lookup fails for users in broken.
+func newTestRateLimiter(t *testing.T, policy RateLimitPolicy, premium, broken map[int]bool) (http.Handler, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	server.SetTime(testNow)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	m := &MiddlewareManager{redisClient: client}
+	m.isPremium = func(ctx context.Context, userID int) (bool, error) {
+		if broken[userID] {
+			return false, errors.New("database is down")
+		}
+		return premium[userID], nil
+	}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	setUser := func(c *gin.Context) {
+		if userID, err := strconv.Atoi(c.GetHeader("X-Test-User")); err == nil {
+			c.Set("user_id", userID)
+		}
+	}
+	engine.GET("/", setUser, m.RateLimit(policy), func(c *gin.Context) { c.Status(http.StatusOK) })
+	return engine, server
+}
+
+func request(handler http.Handler, userID int) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if userID != 0 {
+		req.Header.Set("X-Test-User", strconv.Itoa(userID))
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitAllowsThenDenies(t *testing.T) {
+	handler, server := newTestRateLimiter(t, testRateLimitPolicy, nil, nil)
+
+	for _, wantRemaining := range []string{"2", "1", "0"} {
+		rec := request(handler, 1)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "3" {
+			t.Errorf("X-RateLimit-Limit = %q, want 3", got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != wantRemaining {
+			t.Errorf("X-RateLimit-Remaining = %q, want %q", got, wantRemaining)
+		}
+		if rec.Header().Get("Retry-After") != "" {
+			t.Error("allowed request has a Retry-After header")
+		}
+	}
+
+	rec := request(handler, 1)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status of the 4th request = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+	// One token refills every Period / Limit
+	if got := rec.Header().Get("Retry-After"); got != "20" {
+		t.Errorf("Retry-After = %q, want 20", got)
+	}
+	if got := rec.Header().Get("X-RateLimit-Remaining"); got != "0" {
+		t.Errorf("X-RateLimit-Remaining = %q, want 0", got)
+	}
+	reset, err := strconv.ParseInt(rec.Header().Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		t.Fatalf("X-RateLimit-Reset: %v", err)
+	}
+	if wait := time.Until(time.Unix(reset, 0)); wait < 50*time.Second || wait > 61*time.Second {
+		t.Errorf("X-RateLimit-Reset is %v away, want about a minute", wait)
+	}
+
+	// Other users and anonymous clients have their own buckets
+	if rec := request(handler, 2); rec.Code != http.StatusOK {
+		t.Errorf("other user status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := request(handler, 0); rec.Code != http.StatusOK {
+		t.Errorf("anonymous status = %d, want %d", rec.Code, http.StatusOK)
+	}
+
+	server.SetTime(testNow.Add(20 * time.Second))
+	if rec := request(handler, 1); rec.Code != http.StatusOK {
+		t.Errorf("status after the refill = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if rec := request(handler, 1); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status after using the refilled token = %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitPremiumCapacity(t *testing.T) {
+	const regular, premium, broken = 1, 2, 3
+	handler, _ := newTestRateLimiter(t, testRateLimitPolicy, map[int]bool{premium: true}, map[int]bool{broken: true})
+
+	tests := []struct {
+		name   string
+		userID int
+		want   int
+	}{
+		{"regular", regular, testRateLimitPolicy.Limit},
+		{"premium", premium, testRateLimitPolicy.PremiumLimit},
+		// A failed lookup falls back to the regular capacity
+		{"lookup error", broken, testRateLimitPolicy.Limit},
+		{"anonymous", 0, testRateLimitPolicy.Limit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i := 0; i < test.want; i++ {
+				rec := request(handler, test.userID)
+				if rec.Code != http.StatusOK {
+					t.Fatalf("request %d: status = %d, want %d", i+1, rec.Code, http.StatusOK)
+				}
+				if got := rec.Header().Get("X-RateLimit-Limit"); got != strconv.Itoa(test.want) {
+					t.Fatalf("X-RateLimit-Limit = %q, want %d", got, test.want)
+				}
+			}
+			if rec := request(handler, test.userID); rec.Code != http.StatusTooManyRequests {
+				t.Fatalf("request %d: status = %d, want %d", test.want+1, rec.Code, http.StatusTooManyRequests)
+			}
+		})
+	}
+}
+
+func TestRateLimitWithoutRedis(t *testing.T) {
+	handler, server := newTestRateLimiter(t, testRateLimitPolicy, nil, nil)
+	server.Close()
+
+	for i := 0; i < testRateLimitPolicy.Limit+1; i++ {
+		rec := request(handler, 1)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, want requests to pass while Redis is down", rec.Code)
+		}
+		if rec.Header().Get("X-RateLimit-Limit") != "" {
+			t.Error("rate limit headers were set without a bucket")
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"instagramplusbackend/internal/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Actions that can be gated on a verified email through EMAIL_VERIFICATION_REQUIRED
+const (
+	ActionPost    = "post"
+	ActionComment = "comment"
+)
+
+// RequireVerifiedEmail rejects users with an unverified email when the policy gates the action.
+// It has to run after RequireAuth.
+func (m *MiddlewareManager) RequireVerifiedEmail(action string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !m.verifiedActions[action] && !m.verifiedActions["all"] {
+			c.Next()
+			return
+		}
+
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		verified, err := m.auth.IsEmailVerified(c.Request.Context(), userID.(int))
+		if err != nil {
+			utils.LogError(c, err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if !verified {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "email verification required"})
+			return
+		}
+
+		c.Next()
+	}
+}
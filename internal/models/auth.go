@@ -23,3 +23,61 @@ type LoginRequest struct {
 type TokenRequest struct {
 	Token string `json:"token" binding:"required"`
 }
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Current marks the session making the request
+	Current bool `json:"current"`
+}
+
+type TwoFactorLoginRequest struct {
+	PendingToken string `json:"pending_token" binding:"required"`
+	// Code is a TOTP code or a recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type APIToken struct {
+	ID                int        `json:"id"`
+	Name              string     `json:"name"`
+	Scopes            []string   `json:"scopes"`
+	CreationTimestamp time.Time  `json:"creation_timestamp"`
+	LastUsedAt        *time.Time `json:"last_used_at"`
+}
+
+type CreateAPITokenRequest struct {
+	Name   string   `json:"name" binding:"required,max=64"`
+	Scopes []string `json:"scopes" binding:"required,min=1,dive,oneof=read post admin"`
+}
+
+// Identity is an external login provider linked to an account
+type Identity struct {
+	Provider          string    `json:"provider"`
+	Email             string    `json:"email"`
+	CreationTimestamp time.Time `json:"creation_timestamp"`
+}
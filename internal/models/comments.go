@@ -2,16 +2,26 @@ package models
 
 import "time"
 
+// Comment is a comment or reply, the author fields and LikesCount are null for a removed comment kept as a placeholder for its replies
 type Comment struct {
 	ID                    int       `json:"id"`
 	PostID                int       `json:"post_id"`
-	AuthorID              int       `json:"author_id"`
-	AuthorUsername        string    `json:"author_username"`
-	AuthorProfileImageURL string    `json:"author_profile_image_url"`
+	ParentID              *int      `json:"parent_id"`
+	AuthorID              *int      `json:"author_id"`
+	AuthorUsername        *string   `json:"author_username"`
+	AuthorProfileImageURL *string   `json:"author_profile_image_url"`
 	Content               string    `json:"content"`
 	CreationTimestamp     time.Time `json:"creation_timestamp"`
+	RepliesCount          int       `json:"replies_count"`
+	LikesCount            *int      `json:"likes_count"`
+	AlreadyLiked          bool      `json:"already_liked"`
+	IsDeleted             bool      `json:"is_deleted"`
+	Mentions              []Mention `json:"mentions"`
 }
 
+// DeletedCommentContent replaces the content of a removed comment that still has replies
+const DeletedCommentContent = "[deleted]"
+
 type AddCommentRequest struct {
 	Content string `json:"content" binding:"required,max=255"`
 }
@@ -0,0 +1,10 @@
+package models
+
+// Mention is a resolved "@username" in a post description or comment.
+// Offset and Length count Unicode code points of the text and include the "@".
+type Mention struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Offset   int    `json:"offset"`
+	Length   int    `json:"length"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Notification groups events of the same type on the same target, Actors holds the most recent actors
+type Notification struct {
+	ID                int                 `json:"id"`
+	Type              string              `json:"type"`
+	Text              string              `json:"text"`
+	Actors            []NotificationActor `json:"actors"`
+	ActorsCount       int                 `json:"actors_count"`
+	PostID            *int                `json:"post_id"`
+	CommentID         *int                `json:"comment_id"`
+	IsRead            bool                `json:"is_read"`
+	CreationTimestamp time.Time           `json:"creation_timestamp"`
+}
+
+type NotificationActor struct {
+	UserID          int    `json:"user_id"`
+	Username        string `json:"username"`
+	ProfileImageURL string `json:"profile_image_url"`
+}
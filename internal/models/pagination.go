@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type PaginatedResponse[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor"`
+}
+
+func (p Post) CursorKey() (time.Time, int) {
+	return p.CreationTimestamp, p.ID
+}
+
+func (c Comment) CursorKey() (time.Time, int) {
+	return c.CreationTimestamp, c.ID
+}
+
+func (p ProfileSummary) CursorKey() (time.Time, int) {
+	return p.Since, p.ID
+}
+
+func (n Notification) CursorKey() (time.Time, int) {
+	return n.CreationTimestamp, n.ID
+}
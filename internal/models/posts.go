@@ -3,21 +3,42 @@ package models
 import "time"
 
 type Post struct {
-	ID                    int       `json:"id"`
-	AuthorUsername        string    `json:"author_username"`
-	ImageURL              string    `json:"image_url"`
-	Description           string    `json:"description"`
-	CreationTimestamp     time.Time `json:"create_timestamp"`
-	AuthorName            string    `json:"author_name"`
-	AuthorSurname         string    `json:"author_surname"`
-	LikesCount            int       `json:"likes_count"`
-	AlreadyLiked          bool      `json:"already_liked"`
-	AuthorProfileImageURL string    `json:"author_profile_image_url"`
-	CommentsCount         int       `json:"comments_count"`
+	ID                    int             `json:"id"`
+	AuthorUsername        string          `json:"author_username"`
+	ImageURL              string          `json:"image_url"`
+	ImageRenditions       ImageRenditions `json:"image_renditions"`
+	Description           string          `json:"description"`
+	CreationTimestamp     time.Time       `json:"create_timestamp"`
+	AuthorName            string          `json:"author_name"`
+	AuthorSurname         string          `json:"author_surname"`
+	LikesCount            int             `json:"likes_count"`
+	AlreadyLiked          bool            `json:"already_liked"`
+	AuthorProfileImageURL string          `json:"author_profile_image_url"`
+	CommentsCount         int             `json:"comments_count"`
+	Media                 []PostMedia     `json:"media"`
+	Mentions              []Mention       `json:"mentions"`
+	Score                 float64         `json:"score,omitempty"`
+	Reason                string          `json:"reason,omitempty"`
+}
+
+type PostMedia struct {
+	URL        string          `json:"url"`
+	Width      int             `json:"width"`
+	Height     int             `json:"height"`
+	AltText    string          `json:"alt_text"`
+	Renditions ImageRenditions `json:"renditions"`
+}
+
+type ImageRenditions struct {
+	ThumbnailURL string `json:"thumbnail_url"`
+	FeedURL      string `json:"feed_url"`
+	FullURL      string `json:"full_url"`
 }
 
 type AddPostRequest struct {
 	Description string `json:"description" binding:"required,max=255"`
+	// AltTexts are matched to the uploaded images by position
+	AltTexts []string `json:"alt_texts" binding:"max=10,dive,max=255"`
 }
 
 type UpdatePostRequest struct {
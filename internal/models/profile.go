@@ -3,17 +3,20 @@ package models
 import "time"
 
 type Profile struct {
-	Username          string    `json:"username"`
-	Name              string    `json:"name"`
-	Surname           string    `json:"surname"`
-	Description       string    `json:"description"`
-	ProfileImageURL   string    `json:"profile_image_url"`
-	Gender            string    `json:"gender"`
-	BirthDate         time.Time `json:"birth_date"`
-	CreationTimestamp time.Time `json:"creation_timestamp"`
-	FollowersCount    int       `json:"followers_count"`
-	FollowingCount    int       `json:"following_count"`
-	AlreadyFollowed   bool      `json:"already_followed"`
+	Username               string          `json:"username"`
+	Name                   string          `json:"name"`
+	Surname                string          `json:"surname"`
+	Description            string          `json:"description"`
+	ProfileImageURL        string          `json:"profile_image_url"`
+	ProfileImageRenditions ImageRenditions `json:"profile_image_renditions"`
+	Gender                 string          `json:"gender"`
+	BirthDate              time.Time       `json:"birth_date"`
+	CreationTimestamp      time.Time       `json:"creation_timestamp"`
+	FollowersCount         int             `json:"followers_count"`
+	FollowingCount         int             `json:"following_count"`
+	AlreadyFollowed        bool            `json:"already_followed"`
+	IsPrivate              bool            `json:"is_private"`
+	FollowRequested        bool            `json:"follow_requested"`
 }
 
 type UpdateProfileRequest struct {
@@ -21,4 +24,15 @@ type UpdateProfileRequest struct {
 	Surname     string `json:"surname" binding:"omitempty,max=20"`
 	Description string `json:"description" binding:"omitempty,max=255"`
 	Gender      string `json:"gender" binding:"omitempty,oneof=male female other"`
+	IsPrivate   *bool  `json:"is_private"`
+}
+
+type ProfileSummary struct {
+	ID              int    `json:"-"`
+	Username        string `json:"username"`
+	Name            string `json:"name"`
+	Surname         string `json:"surname"`
+	ProfileImageURL string `json:"profile_image_url"`
+	// Since is when the listed follow, request, block or mute was made, listings are ordered by it
+	Since time.Time `json:"-"`
 }
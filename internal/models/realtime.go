@@ -0,0 +1,25 @@
+package models
+
+// NotificationEvent is pushed to the recipient when a notification is created or gains an actor
+type NotificationEvent struct {
+	ID        int    `json:"id"`
+	Type      string `json:"type"`
+	ActorID   int    `json:"actor_id"`
+	PostID    *int   `json:"post_id"`
+	CommentID *int   `json:"comment_id"`
+}
+
+// CommentEvent is pushed to the watchers of a post when a comment or reply is added
+type CommentEvent struct {
+	ID       int    `json:"id"`
+	PostID   int    `json:"post_id"`
+	ParentID *int   `json:"parent_id"`
+	AuthorID int    `json:"author_id"`
+	Content  string `json:"content"`
+}
+
+// LikesEvent is pushed to the watchers of a post when its like count changes
+type LikesEvent struct {
+	PostID     int `json:"post_id"`
+	LikesCount int `json:"likes_count"`
+}
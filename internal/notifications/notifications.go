@@ -0,0 +1,184 @@
+package notifications
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type Type string
+
+const (
+	TypePostLike       Type = "post_like"
+	TypeCommentLike    Type = "comment_like"
+	TypeComment        Type = "comment"
+	TypeReply          Type = "reply"
+	TypeMention        Type = "mention"
+	TypeFollow         Type = "follow"
+	TypeFollowRequest  Type = "follow_request"
+	TypeFollowAccepted Type = "follow_accepted"
+)
+
+// Event is something an actor did that concerns the recipient.
+// Events with the same recipient, type and target are aggregated into one unread notification.
+type Event struct {
+	Type        Type
+	RecipientID int
+	ActorID     int
+	PostID      *int
+	CommentID   *int
+}
+
+// Service records notifications in Postgres, a notification row is a group of events and
+// notification_actors holds who took part in it
+type Service struct {
+	db *pgxpool.Pool
+}
+
+func NewService(db *pgxpool.Pool) *Service {
+	return &Service{
+		db: db,
+	}
+}
+
+// Notify records the event, joining the recipient's unread notification for the same target when there is one,
+// and returns the notification. Events of users on themselves and of users the recipient blocked or muted
+// are dropped with a zero ID.
+func (s *Service) Notify(ctx context.Context, e Event) (int, error) {
+	if e.RecipientID == e.ActorID {
+		return 0, nil
+	}
+
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var hidden bool
+	err = tx.QueryRow(ctx, `
+		SELECT EXISTS (SELECT 1 FROM blocks WHERE (blocker_id = $1 AND blocked_id = $2) OR (blocker_id = $2 AND blocked_id = $1))
+		    OR EXISTS (SELECT 1 FROM mutes WHERE muter_id = $1 AND muted_id = $2)`, e.RecipientID, e.ActorID).Scan(&hidden)
+	if err != nil || hidden {
+		return 0, err
+	}
+
+	// Concurrent events for the same target must land in one group, so the insert relies on the partial unique
+	// index notifications_unread_group (migrations/023_notifications.sql) and a conflicting group moves to the top
+	// of the list instead
+	var notificationID int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO notifications (user_id, type, post_id, comment_id)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (user_id, type, COALESCE(post_id, 0), COALESCE(comment_id, 0)) WHERE NOT is_read
+		DO UPDATE SET creation_timestamp = NOW()
+		RETURNING id`, e.RecipientID, e.Type, e.PostID, e.CommentID).Scan(&notificationID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO notification_actors (notification_id, actor_id)
+		VALUES ($1, $2)
+		ON CONFLICT (notification_id, actor_id) DO UPDATE SET creation_timestamp = NOW()`, notificationID, e.ActorID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return notificationID, nil
+}
+
+// Retract takes the actor out of notifications for the event, as after an unlike or unfollow.
+// Notifications left without actors are removed.
+func (s *Service) Retract(ctx context.Context, e Event) error {
+	tx, err := s.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM notification_actors
+		WHERE actor_id = $1 AND notification_id IN (
+			SELECT id FROM notifications
+			WHERE user_id = $2 AND type = $3
+			  AND post_id IS NOT DISTINCT FROM $4 AND comment_id IS NOT DISTINCT FROM $5
+		)`, e.ActorID, e.RecipientID, e.Type, e.PostID, e.CommentID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		DELETE FROM notifications n
+		WHERE n.user_id = $1 AND n.type = $2
+		  AND n.post_id IS NOT DISTINCT FROM $3 AND n.comment_id IS NOT DISTINCT FROM $4
+		  AND NOT EXISTS (SELECT 1 FROM notification_actors a WHERE a.notification_id = n.id)`, e.RecipientID, e.Type, e.PostID, e.CommentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// DeleteForPost removes the notifications about the post and its comments within the transaction deleting the post
+func DeleteForPost(ctx context.Context, tx pgx.Tx, postID int) error {
+	return deleteWhere(ctx, tx, "post_id = $1 OR comment_id IN (SELECT id FROM comments WHERE post_id = $1)", postID)
+}
+
+// DeleteForComment removes the notifications about the comment, its likes, replies and mentions,
+// within the transaction deleting the comment
+func DeleteForComment(ctx context.Context, tx pgx.Tx, commentID int) error {
+	return deleteWhere(ctx, tx, "comment_id = $1", commentID)
+}
+
+func deleteWhere(ctx context.Context, tx pgx.Tx, condition string, id int) error {
+	_, err := tx.Exec(ctx, "DELETE FROM notification_actors WHERE notification_id IN (SELECT id FROM notifications WHERE "+condition+")", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM notifications WHERE "+condition, id)
+	return err
+}
+
+// Text renders a notification such as "alice and 12 others liked your post".
+// actors holds the most recent usernames first, count is the number of all actors.
+func Text(t Type, actors []string, count int) string {
+	var who string
+	switch {
+	case len(actors) == 0:
+		who = "someone"
+	case count <= 1:
+		who = actors[0]
+	case count == 2 && len(actors) >= 2:
+		who = actors[0] + " and " + actors[1]
+	case count == 2:
+		who = actors[0] + " and 1 other"
+	default:
+		who = actors[0] + " and " + strconv.Itoa(count-1) + " others"
+	}
+
+	switch t {
+	case TypePostLike:
+		return who + " liked your post"
+	case TypeCommentLike:
+		return who + " liked your comment"
+	case TypeComment:
+		return who + " commented on your post"
+	case TypeReply:
+		return who + " replied to your comment"
+	case TypeMention:
+		return who + " mentioned you"
+	case TypeFollow:
+		return who + " started following you"
+	case TypeFollowRequest:
+		return who + " requested to follow you"
+	case TypeFollowAccepted:
+		return who + " accepted your follow request"
+	}
+	return who
+}
@@ -0,0 +1,32 @@
+package notifications
+
+import "testing"
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name   string
+		t      Type
+		actors []string
+		count  int
+		want   string
+	}{
+		{"single actor", TypePostLike, []string{"alice"}, 1, "alice liked your post"},
+		{"two actors", TypeCommentLike, []string{"alice", "bob"}, 2, "alice and bob liked your comment"},
+		{"two actors, one loaded", TypeComment, []string{"alice"}, 2, "alice and 1 other commented on your post"},
+		{"many actors", TypePostLike, []string{"alice", "bob", "carol"}, 13, "alice and 12 others liked your post"},
+		{"no actors", TypeFollow, nil, 0, "someone started following you"},
+		// Actors who left since are still counted
+		{"no loaded actors", TypeMention, nil, 3, "someone mentioned you"},
+		{"reply", TypeReply, []string{"bob"}, 1, "bob replied to your comment"},
+		{"follow request", TypeFollowRequest, []string{"bob"}, 1, "bob requested to follow you"},
+		{"follow accepted", TypeFollowAccepted, []string{"carol"}, 1, "carol accepted your follow request"},
+		{"unknown type", Type("poke"), []string{"alice"}, 1, "alice"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Text(test.t, test.actors, test.count); got != test.want {
+				t.Errorf("Text(%q, %q, %d) = %q, want %q", test.t, test.actors, test.count, got, test.want)
+			}
+		})
+	}
+}
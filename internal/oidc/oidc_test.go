@@ -0,0 +1,276 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID     = "instagramplus"
+	testClientSecret = "client secret"
+	testRedirectURL  = "http://localhost:5069/auth/oidc/test/callback"
+	testKeyID        = "key-1"
+	testCode         = "authorization-code"
+	testVerifier     = "pkce-verifier"
+)
+
+// testIssuer is an identity provider with discovery, JWKS and token endpoints.
+// The token endpoint answers a valid code with whatever ID token the test prepared.
+type testIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu      sync.Mutex
+	idToken string
+}
+
+func newTestIssuer(t *testing.T) *testIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &testIssuer{t: t, key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (i *testIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	clientID, clientSecret, _ := r.BasicAuth()
+	clientID, _ = url.QueryUnescape(clientID)
+	clientSecret, _ = url.QueryUnescape(clientSecret)
+	switch {
+	case r.Method != http.MethodPost, r.Form.Get("grant_type") != "authorization_code":
+		http.Error(w, `{"error":"unsupported_grant_type"}`, http.StatusBadRequest)
+	case clientID != testClientID || clientSecret != testClientSecret:
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+	case r.Form.Get("code") != testCode, r.Form.Get("redirect_uri") != testRedirectURL,
+		r.Form.Get("code_verifier") != testVerifier:
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+	default:
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "access", "token_type": "Bearer", "id_token": i.idToken})
+	}
+}
+
+// claims are valid for the test client unless a test changes them
+func (i *testIssuer) claims(nonce string) map[string]any {
+	now := time.Now()
+	return map[string]any{
+		"iss":   i.server.URL,
+		"sub":   "subject-1",
+		"aud":   testClientID,
+		"exp":   now.Add(time.Hour).Unix(),
+		"iat":   now.Unix(),
+		"nonce": nonce,
+		"email": "alice@example.com",
+	}
+}
+
+// sign encodes the claims as an ID token with the header fields, "alg" decides how it is signed
+func (i *testIssuer) sign(header, claims map[string]any) string {
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			i.t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signingInput := encode(header) + "." + encode(claims)
+
+	var signature []byte
+	switch header["alg"] {
+	case "RS256":
+		digest := sha256.Sum256([]byte(signingInput))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, i.key, crypto.SHA256, digest[:])
+		if err != nil {
+			i.t.Fatal(err)
+		}
+	case "HS256":
+		// Signed with the public modulus, the classic algorithm confusion attack
+		h := hmac.New(sha256.New, i.key.N.Bytes())
+		h.Write([]byte(signingInput))
+		signature = h.Sum(nil)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (i *testIssuer) issue(idToken string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.idToken = idToken
+}
+
+func (i *testIssuer) provider(t *testing.T) *Provider {
+	provider, err := NewProvider(Config{
+		Name:         "test",
+		Issuer:       i.server.URL + "/",
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return provider
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	target, err := issuer.provider(t).AuthCodeURL(context.Background(), "state", "nonce", CodeChallenge(testVerifier))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if parsed.Path != "/authorize" || query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL ||
+		query.Get("state") != "state" || query.Get("nonce") != "nonce" || query.Get("code_challenge_method") != "S256" ||
+		query.Get("code_challenge") != CodeChallenge(testVerifier) || query.Get("response_type") != "code" {
+		t.Fatalf("unexpected authorization URL %s", target)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newTestIssuer(t)
+	rs256 := map[string]any{"alg": "RS256", "kid": testKeyID, "typ": "JWT"}
+	const nonce = "expected-nonce"
+
+	tests := []struct {
+		name   string
+		header map[string]any
+		change func(claims map[string]any)
+		// tamper changes the signed token
+		tamper func(token string) string
+		// wantErr is part of the expected error, empty for valid tokens
+		wantErr string
+	}{
+		{name: "valid", header: rs256},
+		{name: "audience list", header: rs256, change: func(c map[string]any) { c["aud"] = []string{"other", testClientID} }},
+		{name: "wrong nonce", header: rs256, change: func(c map[string]any) { c["nonce"] = "attacker-nonce" }, wantErr: "nonce mismatch"},
+		{name: "missing nonce", header: rs256, change: func(c map[string]any) { delete(c, "nonce") }, wantErr: "nonce mismatch"},
+		{name: "wrong issuer", header: rs256, change: func(c map[string]any) { c["iss"] = "https://evil.example.com" }, wantErr: "unexpected issuer"},
+		{name: "wrong audience", header: rs256, change: func(c map[string]any) { c["aud"] = "another-client" }, wantErr: "not meant for this client"},
+		{name: "expired", header: rs256, change: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: "expired"},
+		{name: "missing expiry", header: rs256, change: func(c map[string]any) { delete(c, "exp") }, wantErr: "expired"},
+		{name: "issued in the future", header: rs256, change: func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() }, wantErr: "future"},
+		{name: "missing subject", header: rs256, change: func(c map[string]any) { c["sub"] = "" }, wantErr: "invalid id token"},
+		{name: "alg none", header: map[string]any{"alg": "none", "kid": testKeyID}, wantErr: "unsupported signing algorithm"},
+		{name: "alg HS256", header: map[string]any{"alg": "HS256", "kid": testKeyID}, wantErr: "unsupported signing algorithm"},
+		{name: "unknown key", header: map[string]any{"alg": "RS256", "kid": "key-2"}, wantErr: "unknown signing key"},
+		{
+			name:   "tampered claims",
+			header: rs256,
+			tamper: func(token string) string {
+				parts := strings.Split(token, ".")
+				claims, _ := json.Marshal(map[string]any{"iss": issuer.server.URL, "sub": "admin", "aud": testClientID,
+					"exp": time.Now().Add(time.Hour).Unix(), "nonce": nonce})
+				return parts[0] + "." + base64.RawURLEncoding.EncodeToString(claims) + "." + parts[2]
+			},
+			wantErr: "invalid id token",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			claims := issuer.claims(nonce)
+			if test.change != nil {
+				test.change(claims)
+			}
+			token := issuer.sign(test.header, claims)
+			if test.tamper != nil {
+				token = test.tamper(token)
+			}
+			issuer.issue(token)
+
+			got, err := issuer.provider(t).Exchange(context.Background(), testCode, testVerifier, nonce)
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("error = %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Subject != "subject-1" || got.Email != "alice@example.com" {
+				t.Fatalf("claims = %+v", got)
+			}
+		})
+	}
+}
+
+func TestExchangeRejectsInvalidGrant(t *testing.T) {
+	issuer := newTestIssuer(t)
+	issuer.issue(issuer.sign(map[string]any{"alg": "RS256", "kid": testKeyID}, issuer.claims("nonce")))
+	provider := issuer.provider(t)
+
+	if _, err := provider.Exchange(context.Background(), "stolen-code", testVerifier, "nonce"); err == nil {
+		t.Error("an unknown code was exchanged")
+	}
+	if _, err := provider.Exchange(context.Background(), testCode, "other-verifier", "nonce"); err == nil {
+		t.Error("a code was exchanged with the wrong PKCE verifier")
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newTestIssuer(t)
+	provider, err := NewProvider(Config{
+		Name:        "test",
+		Issuer:      issuer.server.URL + "/other",
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The discovery document lives under the configured issuer, a 404 there must fail as well
+	if _, err := provider.AuthCodeURL(context.Background(), "state", "nonce", "challenge"); err == nil {
+		t.Fatal("discovery of a foreign issuer succeeded")
+	}
+}
@@ -0,0 +1,314 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// streamLength is roughly how many events of a topic are kept for resuming, older ones are trimmed
+	streamLength = 1000
+	// streamTTL drops the streams of topics nobody published to for a while
+	streamTTL = 24 * time.Hour
+	// subscriberBuffer is how many events a slow subscriber may lag behind before it is dropped
+	subscriberBuffer = 64
+)
+
+// ErrInvalidEventID is returned for a Last-Event-ID that is not a Redis stream ID
+var ErrInvalidEventID = errors.New("invalid event id")
+
+// Event is delivered to the subscribers of its topic. ID is the Redis stream ID of the event,
+// IDs grow with time so a single ID is enough to resume every topic of a connection.
+type Event struct {
+	ID    string          `json:"id"`
+	Topic string          `json:"topic"`
+	Type  string          `json:"type"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// UserTopic carries the events of a single user, such as notifications
+func UserTopic(userID int) string {
+	return "user:" + strconv.Itoa(userID)
+}
+
+// PostTopic carries the events of a post, such as new comments and like counts
+func PostTopic(postID int) string {
+	return "post:" + strconv.Itoa(postID)
+}
+
+// ParsePostTopic returns the post of a topic made by PostTopic
+func ParsePostTopic(topic string) (int, bool) {
+	raw, found := strings.CutPrefix(topic, "post:")
+	if !found {
+		return 0, false
+	}
+	postID, err := strconv.Atoi(raw)
+	return postID, err == nil
+}
+
+func streamKey(topic string) string {
+	return "realtime_stream:" + topic
+}
+
+const channelPrefix = "realtime:"
+
+func channelKey(topic string) string {
+	return channelPrefix + topic
+}
+
+// Hub publishes events to Redis and fans them out to the local subscribers.
+// Every event is appended to a stream of its topic for resuming and published on a channel,
+// so the subscribers of all backend instances receive it.
+type Hub struct {
+	redis *redis.Client
+
+	mu          sync.Mutex
+	subscribers map[string]map[*Subscription]struct{}
+
+	// syncMu serializes changes of the Redis subscription, which never happen under mu
+	syncMu     sync.Mutex
+	pubsub     *redis.PubSub
+	subscribed map[string]bool
+}
+
+func NewHub(redis *redis.Client) *Hub {
+	return &Hub{
+		redis:       redis,
+		subscribers: map[string]map[*Subscription]struct{}{},
+		subscribed:  map[string]bool{},
+	}
+}
+
+// Publish stores the event and sends it to the subscribers of the topic on every instance
+func (h *Hub) Publish(ctx context.Context, topic, eventType string, data any) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	id, err := h.redis.XAdd(ctx, &redis.XAddArgs{
+		Stream: streamKey(topic),
+		MaxLen: streamLength,
+		Approx: true,
+		Values: map[string]any{"type": eventType, "data": string(encoded)},
+	}).Result()
+	if err != nil {
+		return err
+	}
+	if err := h.redis.Expire(ctx, streamKey(topic), streamTTL).Err(); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(Event{ID: id, Topic: topic, Type: eventType, Data: encoded})
+	if err != nil {
+		return err
+	}
+	return h.redis.Publish(ctx, channelKey(topic), payload).Err()
+}
+
+// Replay returns the events of the topics published after lastEventID, oldest first
+func (h *Hub) Replay(ctx context.Context, topics []string, lastEventID string) ([]Event, error) {
+	if !ValidEventID(lastEventID) {
+		return nil, ErrInvalidEventID
+	}
+
+	events := []Event{}
+	for _, topic := range topics {
+		messages, err := h.redis.XRangeN(ctx, streamKey(topic), "("+lastEventID, "+", streamLength).Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, message := range messages {
+			eventType, _ := message.Values["type"].(string)
+			data, _ := message.Values["data"].(string)
+			events = append(events, Event{ID: message.ID, Topic: topic, Type: eventType, Data: json.RawMessage(data)})
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		return CompareEventIDs(events[i].ID, events[j].ID) < 0
+	})
+	return events, nil
+}
+
+// Subscribe starts delivering the events of the topics published from now on.
+// The subscription is closed when the subscriber falls too far behind, it should then resume with Replay.
+func (h *Hub) Subscribe(ctx context.Context, topics []string) (*Subscription, error) {
+	sub := &Subscription{
+		hub:    h,
+		topics: topics,
+		events: make(chan Event, subscriberBuffer),
+	}
+
+	h.mu.Lock()
+	for _, topic := range topics {
+		if h.subscribers[topic] == nil {
+			h.subscribers[topic] = map[*Subscription]struct{}{}
+		}
+		h.subscribers[topic][sub] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	if err := h.syncChannels(ctx); err != nil {
+		sub.Close()
+		return nil, err
+	}
+	return sub, nil
+}
+
+// syncChannels subscribes the Redis connection to the topics with local subscribers and drops the others.
+// A single connection per instance carries the channels of all local subscribers.
+func (h *Hub) syncChannels(ctx context.Context) error {
+	h.syncMu.Lock()
+	defer h.syncMu.Unlock()
+
+	h.mu.Lock()
+	added, removed := []string{}, []string{}
+	for topic := range h.subscribers {
+		if !h.subscribed[channelKey(topic)] {
+			added = append(added, channelKey(topic))
+		}
+	}
+	for channel := range h.subscribed {
+		if h.subscribers[strings.TrimPrefix(channel, channelPrefix)] == nil {
+			removed = append(removed, channel)
+		}
+	}
+	h.mu.Unlock()
+
+	if len(added) > 0 {
+		if h.pubsub == nil {
+			h.pubsub = h.redis.Subscribe(ctx)
+			go h.dispatch(h.pubsub.Channel())
+		}
+		if err := h.pubsub.Subscribe(ctx, added...); err != nil {
+			return err
+		}
+		for _, channel := range added {
+			h.subscribed[channel] = true
+		}
+	}
+	if len(removed) > 0 {
+		if err := h.pubsub.Unsubscribe(ctx, removed...); err != nil {
+			return err
+		}
+		for _, channel := range removed {
+			delete(h.subscribed, channel)
+		}
+	}
+	return nil
+}
+
+func (h *Hub) dispatch(messages <-chan *redis.Message) {
+	for message := range messages {
+		var event Event
+		if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+			log.Println("realtime: invalid event on", message.Channel, err)
+			continue
+		}
+
+		dropped := false
+		h.mu.Lock()
+		for sub := range h.subscribers[event.Topic] {
+			select {
+			case sub.events <- event:
+			default:
+				h.removeLocked(sub)
+				dropped = true
+			}
+		}
+		h.mu.Unlock()
+
+		if dropped {
+			go h.unsubscribeUnused()
+		}
+	}
+}
+
+func (h *Hub) unsubscribeUnused() {
+	if err := h.syncChannels(context.Background()); err != nil {
+		log.Println("realtime: failed to update subscriptions:", err)
+	}
+}
+
+// removeLocked detaches the subscription and closes its channel, h.mu must be held
+func (h *Hub) removeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+
+	for _, topic := range sub.topics {
+		delete(h.subscribers[topic], sub)
+		if len(h.subscribers[topic]) == 0 {
+			delete(h.subscribers, topic)
+		}
+	}
+}
+
+type Subscription struct {
+	hub    *Hub
+	topics []string
+	events chan Event
+	// closed is guarded by hub.mu
+	closed bool
+}
+
+// Events is closed when the subscription ends
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	s.hub.removeLocked(s)
+	s.hub.mu.Unlock()
+
+	s.hub.unsubscribeUnused()
+}
+
+// parseEventID splits a Redis stream ID such as "1700000000000-0"
+func parseEventID(id string) (uint64, uint64, bool) {
+	ms, seq, found := strings.Cut(id, "-")
+	if !found {
+		return 0, 0, false
+	}
+	msValue, err := strconv.ParseUint(ms, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seqValue, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return msValue, seqValue, true
+}
+
+// CompareEventIDs orders stream IDs like strings.Compare, IDs that do not parse sort first
+func CompareEventIDs(a, b string) int {
+	aMs, aSeq, _ := parseEventID(a)
+	bMs, bSeq, _ := parseEventID(b)
+	switch {
+	case aMs < bMs || aMs == bMs && aSeq < bSeq:
+		return -1
+	case aMs == bMs && aSeq == bSeq:
+		return 0
+	}
+	return 1
+}
+
+// ValidEventID reports whether id can be used to resume, such as a Last-Event-ID header
+func ValidEventID(id string) bool {
+	_, _, ok := parseEventID(id)
+	return ok
+}
@@ -0,0 +1,202 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestHub(t *testing.T) (*Hub, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewHub(client), server
+}
+
+// waitForChannel waits until the Redis channel of the topic has the number of subscribers,
+// events published earlier are not delivered live
+func waitForChannel(t *testing.T, server *miniredis.Miniredis, topic string, want int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if server.PubSubNumSub(channelKey(topic))[channelKey(topic)] == want {
+			return
+		}
+	}
+	t.Fatalf("channel of %s did not reach %d subscribers", topic, want)
+}
+
+func receive(t *testing.T, sub *Subscription) (Event, bool) {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events():
+		return event, ok
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+	}
+	return Event{}, false
+}
+
+func publish(t *testing.T, hub *Hub, topic string, data any) {
+	t.Helper()
+	if err := hub.Publish(context.Background(), topic, "test", data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublishAndReplay(t *testing.T) {
+	hub, server := newTestHub(t)
+	ctx := context.Background()
+
+	// Each stream numbers its own entries, the events are a millisecond apart so that their IDs order them
+	// across topics as they would in production
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i, topic := range []string{PostTopic(1), UserTopic(2), PostTopic(1), PostTopic(3)} {
+		server.SetTime(now.Add(time.Duration(i) * time.Millisecond))
+		publish(t, hub, topic, map[string]int{"n": i + 1})
+	}
+
+	all, err := hub.Replay(ctx, []string{PostTopic(1), UserTopic(2)}, "0-0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Events of several topics come back in publishing order, other topics are left out
+	want := []struct {
+		topic string
+		n     int
+	}{{PostTopic(1), 1}, {UserTopic(2), 2}, {PostTopic(1), 3}}
+	if len(all) != len(want) {
+		t.Fatalf("replayed %d events, want %d", len(all), len(want))
+	}
+	for i, event := range all {
+		var data map[string]int
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			t.Fatal(err)
+		}
+		if event.Topic != want[i].topic || event.Type != "test" || data["n"] != want[i].n {
+			t.Errorf("event %d = %+v, want n %d on %s", i, event, want[i].n, want[i].topic)
+		}
+	}
+
+	// Resuming skips the given event and everything before it
+	rest, err := hub.Replay(ctx, []string{PostTopic(1), UserTopic(2)}, all[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rest) != 2 || rest[0].ID != all[1].ID || rest[1].ID != all[2].ID {
+		t.Errorf("replay after the first event = %+v", rest)
+	}
+
+	if _, err := hub.Replay(ctx, []string{PostTopic(1)}, "latest"); err != ErrInvalidEventID {
+		t.Errorf("replay with an invalid id: %v, want %v", err, ErrInvalidEventID)
+	}
+
+	// Streams of quiet topics expire
+	if ttl := server.TTL(streamKey(PostTopic(1))); ttl != streamTTL {
+		t.Errorf("stream TTL = %v, want %v", ttl, streamTTL)
+	}
+}
+
+func TestSubscribeDeliversLiveEvents(t *testing.T) {
+	hub, server := newTestHub(t)
+	topic := PostTopic(1)
+
+	sub, err := hub.Subscribe(context.Background(), []string{topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForChannel(t, server, topic, 1)
+
+	publish(t, hub, PostTopic(2), "other")
+	publish(t, hub, topic, "live")
+	event, ok := receive(t, sub)
+	if !ok || event.Topic != topic || string(event.Data) != `"live"` || !ValidEventID(event.ID) {
+		t.Fatalf("received %+v, %v", event, ok)
+	}
+
+	sub.Close()
+	if _, ok := <-sub.Events(); ok {
+		t.Error("events channel is open after Close")
+	}
+	waitForChannel(t, server, topic, 0)
+}
+
+func TestSlowSubscriberIsDropped(t *testing.T) {
+	hub, server := newTestHub(t)
+	topic := PostTopic(1)
+
+	slow, err := hub.Subscribe(context.Background(), []string{topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForChannel(t, server, topic, 1)
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		publish(t, hub, topic, i)
+	}
+	// The hub leaves the channel once its only subscriber is dropped
+	waitForChannel(t, server, topic, 0)
+
+	// The buffered events are still delivered, then the channel closes instead of blocking the hub
+	for i := 0; i < subscriberBuffer; i++ {
+		if event, ok := receive(t, slow); !ok || string(event.Data) != strconv.Itoa(i) {
+			t.Fatalf("event %d = %+v, %v", i, event, ok)
+		}
+	}
+	if _, ok := receive(t, slow); ok {
+		t.Fatal("slow subscriber received more than its buffer")
+	}
+
+	// Closing a dropped subscription is harmless and the topic can be subscribed again
+	slow.Close()
+	again, err := hub.Subscribe(context.Background(), []string{topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer again.Close()
+	waitForChannel(t, server, topic, 1)
+	publish(t, hub, topic, "again")
+	if event, ok := receive(t, again); !ok || string(event.Data) != `"again"` {
+		t.Fatalf("received %+v, %v after resubscribing", event, ok)
+	}
+}
+
+func TestCompareEventIDs(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1-0", "1-0", 0},
+		{"1-0", "1-1", -1},
+		{"1-1", "1-0", 1},
+		// Milliseconds compare as numbers, not as strings
+		{"9-0", "10-0", -1},
+		{"1700000000000-5", "1700000000001-0", -1},
+		{"invalid", "0-1", -1},
+		{"invalid", "0-0", 0},
+	}
+	for _, test := range tests {
+		if got := CompareEventIDs(test.a, test.b); got != test.want {
+			t.Errorf("CompareEventIDs(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestValidEventID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"1700000000000-0": true,
+		"0-0":             true,
+		"1700000000000":   false,
+		"-1-0":            false,
+		"1-x":             false,
+		"":                false,
+	} {
+		if got := ValidEventID(id); got != want {
+			t.Errorf("ValidEventID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
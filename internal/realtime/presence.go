@@ -0,0 +1,107 @@
+package realtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// presenceTTL is how long a connection counts as online after its last heartbeat,
+// connections of crashed instances go offline on their own once it passes
+const presenceTTL = 90 * time.Second
+
+// EventPresence is published on the presence topic of a user when they come online or go offline
+const EventPresence = "presence"
+
+type PresenceEvent struct {
+	UserID int  `json:"user_id"`
+	Online bool `json:"online"`
+}
+
+// PresenceTopic carries the online and offline events of a user
+func PresenceTopic(userID int) string {
+	return "presence:" + strconv.Itoa(userID)
+}
+
+// presenceKey is a sorted set of the user's connections scored by when they expire
+func presenceKey(userID int) string {
+	return "presence_connections:" + strconv.Itoa(userID)
+}
+
+// NewConnectionID identifies a connection across instances
+func NewConnectionID() (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// Connect marks the connection online and announces the user when it is their first connection
+func (h *Hub) Connect(ctx context.Context, userID int, connectionID string) error {
+	online, err := h.onlineConnections(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if err := h.Heartbeat(ctx, userID, connectionID); err != nil {
+		return err
+	}
+	if online > 0 {
+		return nil
+	}
+	return h.Publish(ctx, PresenceTopic(userID), EventPresence, PresenceEvent{UserID: userID, Online: true})
+}
+
+// Heartbeat keeps the connection online for another presenceTTL
+func (h *Hub) Heartbeat(ctx context.Context, userID int, connectionID string) error {
+	now := time.Now()
+	key := presenceKey(userID)
+
+	pipe := h.redis.TxPipeline()
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.Add(presenceTTL).UnixMilli()), Member: connectionID})
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.UnixMilli(), 10))
+	pipe.Expire(ctx, key, presenceTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Disconnect removes the connection and announces the user as offline when it was their last one
+func (h *Hub) Disconnect(ctx context.Context, userID int, connectionID string) error {
+	if err := h.redis.ZRem(ctx, presenceKey(userID), connectionID).Err(); err != nil {
+		return err
+	}
+	online, err := h.onlineConnections(ctx, userID)
+	if err != nil || online > 0 {
+		return err
+	}
+	return h.Publish(ctx, PresenceTopic(userID), EventPresence, PresenceEvent{UserID: userID, Online: false})
+}
+
+// Online reports which of the users have a live connection on any instance
+func (h *Hub) Online(ctx context.Context, userIDs []int) (map[int]bool, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	pipe := h.redis.Pipeline()
+	counts := make([]*redis.IntCmd, len(userIDs))
+	for i, userID := range userIDs {
+		counts[i] = pipe.ZCount(ctx, presenceKey(userID), "("+now, "+inf")
+	}
+	if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+		return nil, err
+	}
+
+	online := map[int]bool{}
+	for i, userID := range userIDs {
+		online[userID] = counts[i].Val() > 0
+	}
+	return online, nil
+}
+
+func (h *Hub) onlineConnections(ctx context.Context, userID int) (int64, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	return h.redis.ZCount(ctx, presenceKey(userID), "("+now, "+inf").Result()
+}
@@ -1,18 +1,35 @@
 package routes
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"instagramplusbackend/auth"
+	"instagramplusbackend/internal/middleware"
 	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/oidc"
 	"instagramplusbackend/internal/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
+// clientInfo describes the requesting device for the session list
+func clientInfo(c *gin.Context) auth.ClientInfo {
+	return auth.ClientInfo{UserAgent: c.Request.UserAgent(), IP: c.ClientIP()}
+}
+
 func (r *RoutesManager) RegisterAuthRoutes(router *gin.Engine) {
 	authRouter := router.Group("/auth")
+
+	// These routes are unauthenticated, so the buckets are per client address
+	registerLimit := r.middleware.RateLimit(middleware.RateLimitPolicy{Name: "auth:register", Limit: 5, Period: time.Hour})
+	emailLimit := r.middleware.RateLimit(middleware.RateLimitPolicy{Name: "auth:email", Limit: 5, Period: time.Hour})
 	{
-		authRouter.POST("/register", func(c *gin.Context) {
+		authRouter.POST("/register", registerLimit, func(c *gin.Context) {
 			var req models.RegisterRequest
 			if err := c.ShouldBindJSON(&req); err != nil {
 				println(err.Error())
@@ -20,7 +37,7 @@ func (r *RoutesManager) RegisterAuthRoutes(router *gin.Engine) {
 				return
 			}
 
-			userID, token, err := r.auth.Register(c.Request.Context(), req.Username, req.Password, req.Email)
+			userID, token, err := r.auth.Register(c.Request.Context(), req.Username, req.Password, req.Email, clientInfo(c))
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
@@ -36,8 +53,13 @@ func (r *RoutesManager) RegisterAuthRoutes(router *gin.Engine) {
 				return
 			}
 
-			var isAdmin, isPremium bool
-			err = r.pgClient.QueryRow(c.Request.Context(), `SELECT is_admin, is_premium FROM users WHERE id = $1`, userID).Scan(&isAdmin, &isPremium)
+			// The account is usable right away, a failed email can be resent later
+			if err := r.auth.SendEmailVerification(c.Request.Context(), userID, req.Email); err != nil {
+				utils.LogError(c, err)
+			}
+
+			var isAdmin, isPremium, emailVerified bool
+			err = r.pgClient.QueryRow(c.Request.Context(), `SELECT is_admin, is_premium, email_verified FROM users WHERE id = $1`, userID).Scan(&isAdmin, &isPremium, &emailVerified)
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user roles"})
@@ -46,7 +68,7 @@ func (r *RoutesManager) RegisterAuthRoutes(router *gin.Engine) {
 
 			c.SetCookie("AUTH", token, 0, "/", "", false, true)
 
-			c.JSON(http.StatusOK, gin.H{"username": req.Username, "user_id": userID, "is_admin": isAdmin, "is_premium": isPremium})
+			c.JSON(http.StatusOK, gin.H{"username": req.Username, "user_id": userID, "is_admin": isAdmin, "is_premium": isPremium, "email_verified": emailVerified})
 		})
 
 		authRouter.POST("/login", func(c *gin.Context) {
@@ -56,25 +78,109 @@ func (r *RoutesManager) RegisterAuthRoutes(router *gin.Engine) {
 				return
 			}
 
-			userID, token, err := r.auth.Login(c.Request.Context(), req.Username, req.Password)
+			userID, token, err := r.auth.Login(c.Request.Context(), req.Username, req.Password, clientInfo(c))
 			if err != nil {
+				if errors.Is(err, auth.ErrTwoFactorRequired) {
+					// The password was right, the session is only issued by /login/2fa
+					c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "pending_token": token})
+					return
+				}
+				if respondLockout(c, err) {
+					return
+				}
 				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 				return
 			}
 
-			var isAdmin, isPremium bool
-			err = r.pgClient.QueryRow(c.Request.Context(), `SELECT is_admin, is_premium FROM users WHERE id = $1`, userID).Scan(&isAdmin, &isPremium)
+			r.respondWithSession(c, userID, token)
+		})
+
+		authRouter.POST("/login/2fa", func(c *gin.Context) {
+			var req models.TwoFactorLoginRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+
+			userID, token, err := r.auth.CompleteTwoFactorLogin(c.Request.Context(), req.PendingToken, req.Code, clientInfo(c))
 			if err != nil {
+				if errors.Is(err, auth.ErrInvalidTwoFactorCode) || errors.Is(err, auth.ErrInvalidPendingToken) {
+					c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+					return
+				}
+				if respondLockout(c, err) {
+					return
+				}
 				utils.LogError(c, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user roles"})
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to log in"})
 				return
 			}
 
-			c.SetCookie("AUTH", token, 0, "/", "", false, true)
-
-			c.JSON(http.StatusOK, gin.H{"username": req.Username, "user_id": userID, "is_admin": isAdmin, "is_premium": isPremium})
+			r.respondWithSession(c, userID, token)
 		})
 
+		twoFactorRouter := authRouter.Group("/2fa")
+		twoFactorRouter.Use(r.middleware.RequireAuth(), r.middleware.RequireSession())
+		{
+			twoFactorRouter.POST("/enroll", func(c *gin.Context) {
+				enrollment, err := r.auth.EnrollTwoFactor(c.Request.Context(), c.GetInt("user_id"))
+				if err != nil {
+					if errors.Is(err, auth.ErrTwoFactorAlreadyEnabled) {
+						c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to start two-factor enrollment"})
+					return
+				}
+
+				c.JSON(http.StatusOK, enrollment)
+			})
+
+			twoFactorRouter.POST("/confirm", func(c *gin.Context) {
+				var req models.TwoFactorCodeRequest
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+					return
+				}
+
+				recoveryCodes, err := r.auth.ConfirmTwoFactor(c.Request.Context(), c.GetInt("user_id"), req.Code)
+				if err != nil {
+					if errors.Is(err, auth.ErrInvalidTwoFactorCode) || errors.Is(err, auth.ErrTwoFactorAlreadyEnabled) || errors.Is(err, auth.ErrTwoFactorNotEnrolled) {
+						c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to enable two-factor authentication"})
+					return
+				}
+
+				// Recovery codes are stored hashed, this is the only time they can be shown
+				c.JSON(http.StatusOK, gin.H{"recovery_codes": recoveryCodes})
+			})
+
+			twoFactorRouter.POST("/disable", func(c *gin.Context) {
+				var req models.DisableTwoFactorRequest
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+					return
+				}
+
+				err := r.auth.DisableTwoFactor(c.Request.Context(), c.GetInt("user_id"), req.Password, req.Code)
+				if err != nil {
+					if errors.Is(err, auth.ErrInvalidTwoFactorCode) || errors.Is(err, auth.ErrTwoFactorNotEnabled) || errors.Is(err, auth.ErrInvalidPassword) {
+						c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to disable two-factor authentication"})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+		}
+
 		authRouter.POST("/validate", func(c *gin.Context) {
 			var req models.TokenRequest
 			if err := c.ShouldBindJSON(&req); err != nil {
@@ -91,6 +197,253 @@ func (r *RoutesManager) RegisterAuthRoutes(router *gin.Engine) {
 			c.JSON(http.StatusOK, gin.H{})
 		})
 
+		authRouter.POST("/forgot-password", emailLimit, func(c *gin.Context) {
+			var req models.ForgotPasswordRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+
+			err := r.auth.RequestPasswordReset(c.Request.Context(), req.Email)
+			if errors.Is(err, auth.ErrAmbiguousResetEmail) {
+				// Answered like any other address, the accounts have to be sorted out by support
+				utils.LogError(c, err)
+			} else if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send reset email"})
+				return
+			}
+
+			// The response is the same whether the email is registered or not
+			c.JSON(http.StatusOK, gin.H{"message": "If the email is registered, a reset link has been sent"})
+		})
+
+		authRouter.POST("/reset-password", func(c *gin.Context) {
+			var req models.ResetPasswordRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+
+			err := r.auth.ResetPassword(c.Request.Context(), req.Token, req.NewPassword)
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidResetToken) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to reset password"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+		})
+
+		authRouter.POST("/verify-email", func(c *gin.Context) {
+			var req models.VerifyEmailRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+
+			err := r.auth.VerifyEmail(c.Request.Context(), req.Token)
+			if err != nil {
+				if errors.Is(err, auth.ErrInvalidVerificationToken) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to verify email"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+		})
+
+		authRouter.POST("/verify-email/resend", r.middleware.RequireAuth(), r.middleware.RequireSession(), emailLimit, func(c *gin.Context) {
+			err := r.auth.ResendEmailVerification(c.Request.Context(), c.GetInt("user_id"))
+			if err != nil {
+				if errors.Is(err, auth.ErrEmailAlreadyVerified) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to send verification email"})
+				return
+			}
+
+			c.JSON(http.StatusOK, gin.H{"message": "Verification email sent"})
+		})
+
+		sessionsRouter := authRouter.Group("/sessions")
+		sessionsRouter.Use(r.middleware.RequireAuth(), r.middleware.RequireSession())
+		{
+			sessionsRouter.GET("", func(c *gin.Context) {
+				sessions, err := r.auth.ListSessions(c.Request.Context(), c.GetInt("user_id"), c.GetString("session_id"))
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list sessions"})
+					return
+				}
+
+				c.JSON(http.StatusOK, sessions)
+			})
+
+			// Revokes every session except the current one
+			sessionsRouter.DELETE("", func(c *gin.Context) {
+				if err := r.auth.RevokeOtherSessions(c.Request.Context(), c.GetInt("user_id"), c.GetString("session_id")); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke sessions"})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+
+			sessionsRouter.DELETE("/:session_id", func(c *gin.Context) {
+				err := r.auth.RevokeSession(c.Request.Context(), c.GetInt("user_id"), c.Param("session_id"))
+				if err != nil {
+					if errors.Is(err, auth.ErrSessionNotFound) {
+						c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke session"})
+					return
+				}
+
+				if c.Param("session_id") == c.GetString("session_id") {
+					c.SetCookie("AUTH", "", -1, "/", "", false, true)
+				}
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+		}
+
+		tokensRouter := authRouter.Group("/tokens")
+		tokensRouter.Use(r.middleware.RequireAuth(), r.middleware.RequireSession())
+		{
+			tokensRouter.GET("", func(c *gin.Context) {
+				tokens, err := r.auth.ListAPITokens(c.Request.Context(), c.GetInt("user_id"))
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				c.JSON(http.StatusOK, tokens)
+			})
+
+			tokensRouter.POST("", func(c *gin.Context) {
+				var req models.CreateAPITokenRequest
+				if err := c.ShouldBindJSON(&req); err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+					return
+				}
+
+				apiToken, token, err := r.auth.CreateAPIToken(c.Request.Context(), c.GetInt("user_id"), req.Name, req.Scopes)
+				if err != nil {
+					if errors.Is(err, auth.ErrAdminScopeNotAllowed) {
+						c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				// The token is stored hashed, this is the only time it can be shown
+				c.JSON(http.StatusCreated, gin.H{"token": token, "api_token": apiToken})
+			})
+
+			tokensRouter.DELETE("/:token_id", func(c *gin.Context) {
+				tokenID, err := strconv.Atoi(c.Param("token_id"))
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid token id"})
+					return
+				}
+
+				err = r.auth.RevokeAPIToken(c.Request.Context(), c.GetInt("user_id"), tokenID)
+				if err != nil {
+					if errors.Is(err, auth.ErrAPITokenNotFound) {
+						c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+		}
+
+		oidcRouter := authRouter.Group("/oidc")
+		{
+			oidcRouter.GET("/providers", func(c *gin.Context) {
+				c.JSON(http.StatusOK, gin.H{"providers": r.auth.OIDCProviders()})
+			})
+
+			oidcRouter.GET("/:provider/login", func(c *gin.Context) {
+				target, state, err := r.auth.BeginOIDC(c.Request.Context(), c.Param("provider"), 0)
+				if err != nil {
+					if errors.Is(err, oidc.ErrUnknownProvider) {
+						c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+					return
+				}
+
+				setOIDCStateCookie(c, state)
+				c.Redirect(http.StatusFound, target)
+			})
+
+			// The provider redirects the browser here, so outcomes are reported by redirecting to the frontend
+			oidcRouter.GET("/:provider/callback", func(c *gin.Context) {
+				browserState, _ := c.Cookie(oidcStateCookie)
+				clearOIDCStateCookie(c)
+
+				if providerError := c.Query("error"); providerError != "" {
+					c.Redirect(http.StatusFound, auth.AppURL()+"/login?error="+url.QueryEscape(providerError))
+					return
+				}
+
+				result, err := r.auth.CompleteOIDC(c.Request.Context(), c.Param("provider"), c.Query("state"), browserState, c.Query("code"), clientInfo(c))
+				if errors.Is(err, auth.ErrTwoFactorRequired) {
+					// The fragment keeps the pending token out of server logs and Referer headers
+					c.Redirect(http.StatusFound, auth.AppURL()+"/login/2fa#pending_token="+url.QueryEscape(result.Token))
+					return
+				}
+				if err != nil {
+					reason := "login_failed"
+					switch {
+					case errors.Is(err, auth.ErrEmailInUse):
+						reason = "email_in_use"
+					case errors.Is(err, auth.ErrIdentityLinkedElsewhere):
+						reason = "identity_linked_elsewhere"
+					case errors.Is(err, auth.ErrProviderAlreadyLinked):
+						reason = "provider_already_linked"
+					case errors.Is(err, auth.ErrInvalidOIDCState), errors.Is(err, oidc.ErrUnknownProvider):
+						reason = "invalid_state"
+					default:
+						utils.LogError(c, err)
+					}
+					c.Redirect(http.StatusFound, auth.AppURL()+"/login?error="+reason)
+					return
+				}
+
+				if result.Linked {
+					c.Redirect(http.StatusFound, auth.AppURL()+"/settings?linked="+url.QueryEscape(c.Param("provider")))
+					return
+				}
+
+				c.SetCookie("AUTH", result.Token, 0, "/", "", false, true)
+				c.Redirect(http.StatusFound, auth.AppURL()+"/")
+			})
+		}
+
 		authRouter.POST("/logout", func(c *gin.Context) {
 			token, err := c.Cookie("AUTH")
 			if err != nil {
@@ -109,3 +462,50 @@ func (r *RoutesManager) RegisterAuthRoutes(router *gin.Engine) {
 		})
 	}
 }
+
+// respondLockout answers locked accounts with 423 and throttled addresses with 429, both with Retry-After
+func respondLockout(c *gin.Context, err error) bool {
+	var lockoutErr *auth.LockoutError
+	if !errors.As(err, &lockoutErr) {
+		return false
+	}
+
+	status := http.StatusLocked
+	if lockoutErr.Scope == auth.LockoutScopeIP {
+		status = http.StatusTooManyRequests
+	}
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockoutErr.RetryAfter.Seconds()))))
+	c.JSON(status, gin.H{"error": err.Error(), "retry_after": int(math.Ceil(lockoutErr.RetryAfter.Seconds()))})
+	return true
+}
+
+// oidcStateCookie binds an OIDC flow to the browser that started it
+const oidcStateCookie = "OIDC_STATE"
+
+// setOIDCStateCookie is sent along with the redirect to the provider. It is Lax, so it comes back with
+// the top-level redirect to the callback, and limited to the callback path.
+func setOIDCStateCookie(c *gin.Context, state string) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(auth.OIDCStateTTL.Seconds()), "/auth/oidc", "", false, true)
+}
+
+func clearOIDCStateCookie(c *gin.Context) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, "", -1, "/auth/oidc", "", false, true)
+}
+
+// respondWithSession sets the session cookie and returns the roles of the logged in user
+func (r *RoutesManager) respondWithSession(c *gin.Context, userID int, token string) {
+	var username string
+	var isAdmin, isPremium, emailVerified bool
+	err := r.pgClient.QueryRow(c.Request.Context(), `SELECT username, is_admin, is_premium, email_verified FROM users WHERE id = $1`, userID).Scan(&username, &isAdmin, &isPremium, &emailVerified)
+	if err != nil {
+		utils.LogError(c, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch user roles"})
+		return
+	}
+
+	c.SetCookie("AUTH", token, 0, "/", "", false, true)
+
+	c.JSON(http.StatusOK, gin.H{"username": username, "user_id": userID, "is_admin": isAdmin, "is_premium": isPremium, "email_verified": emailVerified})
+}
@@ -1,10 +1,15 @@
 package routes
 
 import (
+	"context"
+	"instagramplusbackend/internal/middleware"
 	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/notifications"
+	"instagramplusbackend/internal/realtime"
 	"instagramplusbackend/internal/utils"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
@@ -13,6 +18,9 @@ import (
 func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 	commentsRouter := router.Group("/comments")
 	commentsRouter.Use(r.middleware.RequireAuth())
+
+	createLimit := r.middleware.RateLimit(middleware.RateLimitPolicy{Name: "comments:create", Limit: 30, Period: 10 * time.Minute, PremiumLimit: 60})
+	likeLimit := r.middleware.RateLimit(middleware.RateLimitPolicy{Name: "comments:like", Limit: 120, Period: time.Hour, PremiumLimit: 300})
 	{
 		commentsRouter.GET("/post/:post_id", func(c *gin.Context) {
 			postID, err := strconv.Atoi(c.Param("post_id"))
@@ -20,14 +28,40 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post id"})
 				return
 			}
+			visible, err := r.canViewPost(c.Request.Context(), c.GetInt("user_id"), postID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if !visible {
+				c.JSON(http.StatusNotFound, gin.H{"error": "post not found"})
+				return
+			}
+
+			page, err := utils.ParsePagination(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursorTimestamp, cursorID := page.CursorArgs()
 
 			rows, err := r.pgClient.Query(c.Request.Context(), `
-				SELECT c.id, c.post_id, c.author_id, u.username, up.profile_image_url, c.content, c.creation_timestamp
+				SELECT c.id, c.post_id, c.parent_id,
+				   CASE WHEN NOT c.is_deleted THEN c.author_id END, CASE WHEN NOT c.is_deleted THEN u.username END,
+				   CASE WHEN NOT c.is_deleted THEN up.profile_image_url END,
+				   CASE WHEN c.is_deleted THEN $2 ELSE c.content END, c.creation_timestamp,
+				   (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS replies_count,
+				   CASE WHEN NOT c.is_deleted THEN (SELECT COUNT(*) FROM comments_likes l WHERE l.comment_id = c.id) END AS likes_count,
+				   EXISTS (SELECT 1 FROM comments_likes l WHERE l.comment_id = c.id AND l.user_id = $6) AS already_liked,
+				   c.is_deleted
 				FROM comments c
 				JOIN users u ON c.author_id = u.id
 				JOIN user_profiles up ON up.user_id = u.id
-				WHERE c.post_id = $1
-				ORDER BY c.creation_timestamp ASC`, postID)
+				WHERE c.post_id = $1 AND c.parent_id IS NULL AND `+notBlockedSQL("c.author_id", "$6")+` AND `+notMutedSQL("c.author_id", "$6")+`
+				  AND ($3 IS NULL OR (c.creation_timestamp, c.id) > ($3, $4))
+				ORDER BY c.creation_timestamp ASC, c.id ASC
+				LIMIT $5`, postID, models.DeletedCommentContent, cursorTimestamp, cursorID, page.FetchLimit(), c.GetInt("user_id"))
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
@@ -38,7 +72,7 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 			comments := []models.Comment{}
 			for rows.Next() {
 				var comment models.Comment
-				err := rows.Scan(&comment.ID, &comment.PostID, &comment.AuthorID, &comment.AuthorUsername, &comment.AuthorProfileImageURL, &comment.Content, &comment.CreationTimestamp)
+				err := rows.Scan(&comment.ID, &comment.PostID, &comment.ParentID, &comment.AuthorID, &comment.AuthorUsername, &comment.AuthorProfileImageURL, &comment.Content, &comment.CreationTimestamp, &comment.RepliesCount, &comment.LikesCount, &comment.AlreadyLiked, &comment.IsDeleted)
 				if err != nil {
 					utils.LogError(c, err)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
@@ -46,15 +80,31 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 				}
 				comments = append(comments, comment)
 			}
-			c.JSON(http.StatusOK, comments)
+			resp := utils.Paginate(comments, page, models.Comment.CursorKey)
+			if err := r.attachCommentMentions(c.Request.Context(), resp.Items); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, resp)
 		})
 
-		commentsRouter.POST("/post/:post_id", func(c *gin.Context) {
+		commentsRouter.POST("/post/:post_id", createLimit, r.middleware.RequireVerifiedEmail(middleware.ActionComment), func(c *gin.Context) {
 			postID, err := strconv.Atoi(c.Param("post_id"))
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post id"})
 				return
 			}
+			visible, err := r.canViewPost(c.Request.Context(), c.GetInt("user_id"), postID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if !visible {
+				c.JSON(http.StatusNotFound, gin.H{"error": "post not found"})
+				return
+			}
 			userID, exists := c.Get("user_id")
 			if !exists {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
@@ -65,9 +115,7 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 				return
 			}
-			_, err = r.pgClient.Exec(c.Request.Context(),
-				"INSERT INTO comments (post_id, author_id, content) VALUES ($1, $2, $3)",
-				postID, userID, req.Content)
+			commentID, mentioned, err := r.insertComment(c.Request.Context(), postID, nil, userID.(int), req.Content)
 			if err != nil {
 				if utils.IsForeignKeyViolationPgxError(err, "comments_post_id_fkey") {
 					c.JSON(http.StatusBadRequest, gin.H{"error": "no post with id " + c.Param("post_id")})
@@ -77,6 +125,14 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
+
+			if authorID, err := r.postAuthorID(c.Request.Context(), postID); err != nil {
+				utils.LogError(c, err)
+			} else {
+				r.notify(c, notifications.Event{Type: notifications.TypeComment, RecipientID: authorID, ActorID: userID.(int), PostID: &postID})
+			}
+			r.notifyMentions(c, userID.(int), postID, &commentID, mentioned)
+			r.publish(c, realtime.PostTopic(postID), EventComment, models.CommentEvent{ID: commentID, PostID: postID, AuthorID: userID.(int), Content: req.Content})
 			c.JSON(http.StatusOK, gin.H{})
 		})
 
@@ -86,14 +142,31 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment id"})
 				return
 			}
+			visible, err := r.canViewComment(c.Request.Context(), c.GetInt("user_id"), commentID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if !visible {
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+				return
+			}
 			row := r.pgClient.QueryRow(c.Request.Context(), `
-				SELECT c.id, c.post_id, c.author_id, u.username, up.profile_image_url, c.content, c.creation_timestamp
+				SELECT c.id, c.post_id, c.parent_id,
+				   CASE WHEN NOT c.is_deleted THEN c.author_id END, CASE WHEN NOT c.is_deleted THEN u.username END,
+				   CASE WHEN NOT c.is_deleted THEN up.profile_image_url END,
+				   CASE WHEN c.is_deleted THEN $2 ELSE c.content END, c.creation_timestamp,
+				   (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS replies_count,
+				   CASE WHEN NOT c.is_deleted THEN (SELECT COUNT(*) FROM comments_likes l WHERE l.comment_id = c.id) END AS likes_count,
+				   EXISTS (SELECT 1 FROM comments_likes l WHERE l.comment_id = c.id AND l.user_id = $3) AS already_liked,
+				   c.is_deleted
 				FROM comments c
 				JOIN users u ON c.author_id = u.id
 				JOIN user_profiles up ON up.user_id = u.id
-				WHERE c.id = $1`, commentID)
+				WHERE c.id = $1`, commentID, models.DeletedCommentContent, c.GetInt("user_id"))
 			var comment models.Comment
-			err = row.Scan(&comment.ID, &comment.PostID, &comment.AuthorID, &comment.AuthorUsername, &comment.AuthorProfileImageURL, &comment.Content, &comment.CreationTimestamp)
+			err = row.Scan(&comment.ID, &comment.PostID, &comment.ParentID, &comment.AuthorID, &comment.AuthorUsername, &comment.AuthorProfileImageURL, &comment.Content, &comment.CreationTimestamp, &comment.RepliesCount, &comment.LikesCount, &comment.AlreadyLiked, &comment.IsDeleted)
 			if err != nil {
 				if err == pgx.ErrNoRows {
 					c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
@@ -103,7 +176,154 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
-			c.JSON(http.StatusOK, comment)
+			comments := []models.Comment{comment}
+			if err := r.attachCommentMentions(c.Request.Context(), comments); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, comments[0])
+		})
+
+		commentsRouter.GET(":comment_id/replies", func(c *gin.Context) {
+			commentID, err := strconv.Atoi(c.Param("comment_id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment id"})
+				return
+			}
+			visible, err := r.canViewComment(c.Request.Context(), c.GetInt("user_id"), commentID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if !visible {
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+				return
+			}
+
+			page, err := utils.ParsePagination(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursorTimestamp, cursorID := page.CursorArgs()
+
+			rows, err := r.pgClient.Query(c.Request.Context(), `
+				SELECT c.id, c.post_id, c.parent_id,
+				   CASE WHEN NOT c.is_deleted THEN c.author_id END, CASE WHEN NOT c.is_deleted THEN u.username END,
+				   CASE WHEN NOT c.is_deleted THEN up.profile_image_url END,
+				   CASE WHEN c.is_deleted THEN $2 ELSE c.content END, c.creation_timestamp,
+				   (SELECT COUNT(*) FROM comments r WHERE r.parent_id = c.id) AS replies_count,
+				   CASE WHEN NOT c.is_deleted THEN (SELECT COUNT(*) FROM comments_likes l WHERE l.comment_id = c.id) END AS likes_count,
+				   EXISTS (SELECT 1 FROM comments_likes l WHERE l.comment_id = c.id AND l.user_id = $6) AS already_liked,
+				   c.is_deleted
+				FROM comments c
+				JOIN users u ON c.author_id = u.id
+				JOIN user_profiles up ON up.user_id = u.id
+				WHERE c.parent_id = $1 AND `+notBlockedSQL("c.author_id", "$6")+` AND `+notMutedSQL("c.author_id", "$6")+`
+				  AND ($3 IS NULL OR (c.creation_timestamp, c.id) > ($3, $4))
+				ORDER BY c.creation_timestamp ASC, c.id ASC
+				LIMIT $5`, commentID, models.DeletedCommentContent, cursorTimestamp, cursorID, page.FetchLimit(), c.GetInt("user_id"))
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer rows.Close()
+
+			replies := []models.Comment{}
+			for rows.Next() {
+				var comment models.Comment
+				err := rows.Scan(&comment.ID, &comment.PostID, &comment.ParentID, &comment.AuthorID, &comment.AuthorUsername, &comment.AuthorProfileImageURL, &comment.Content, &comment.CreationTimestamp, &comment.RepliesCount, &comment.LikesCount, &comment.AlreadyLiked, &comment.IsDeleted)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				replies = append(replies, comment)
+			}
+			resp := utils.Paginate(replies, page, models.Comment.CursorKey)
+			if err := r.attachCommentMentions(c.Request.Context(), resp.Items); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, resp)
+		})
+
+		commentsRouter.POST(":comment_id/replies", createLimit, r.middleware.RequireVerifiedEmail(middleware.ActionComment), func(c *gin.Context) {
+			commentID, err := strconv.Atoi(c.Param("comment_id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment id"})
+				return
+			}
+			visible, err := r.canViewComment(c.Request.Context(), c.GetInt("user_id"), commentID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if !visible {
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+				return
+			}
+			userID, exists := c.Get("user_id")
+			if !exists {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+				return
+			}
+			var req models.AddCommentRequest
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+
+			var postID int
+			var grandparentID *int
+			var isDeleted bool
+			err = r.pgClient.QueryRow(c.Request.Context(),
+				"SELECT post_id, parent_id, is_deleted FROM comments WHERE id = $1", commentID).Scan(&postID, &grandparentID, &isDeleted)
+			if err != nil {
+				if err == pgx.ErrNoRows {
+					c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if isDeleted {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "cannot reply to a deleted comment"})
+				return
+			}
+
+			// Threads are one level deep, replies to a reply are attached to the top-level comment
+			parentID := commentID
+			if grandparentID != nil {
+				parentID = *grandparentID
+			}
+
+			replyID, mentioned, err := r.insertComment(c.Request.Context(), postID, &parentID, userID.(int), req.Content)
+			if err != nil {
+				if utils.IsForeignKeyViolationPgxError(err) {
+					c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			// The author of the comment that was answered is notified, also when the reply went to the top-level comment
+			if authorID, err := r.commentAuthorID(c.Request.Context(), commentID); err != nil {
+				utils.LogError(c, err)
+			} else {
+				r.notify(c, notifications.Event{Type: notifications.TypeReply, RecipientID: authorID, ActorID: userID.(int), PostID: &postID, CommentID: &commentID})
+			}
+			r.notifyMentions(c, userID.(int), postID, &replyID, mentioned)
+			r.publish(c, realtime.PostTopic(postID), EventComment, models.CommentEvent{ID: replyID, PostID: postID, ParentID: &parentID, AuthorID: userID.(int), Content: req.Content})
+			c.JSON(http.StatusOK, gin.H{})
 		})
 
 		commentsRouter.PUT(":comment_id", r.middleware.RequireCommentOwnership("comment_id"), func(c *gin.Context) {
@@ -117,14 +337,41 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 				return
 			}
-			_, err = r.pgClient.Exec(c.Request.Context(),
-				"UPDATE comments SET content = $1 WHERE id = $2",
-				req.Content, commentID)
+			tx, err := r.pgClient.Begin(c.Request.Context())
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer tx.Rollback(c.Request.Context())
+
+			var postID int
+			err = tx.QueryRow(c.Request.Context(),
+				"UPDATE comments SET content = $1 WHERE id = $2 AND NOT is_deleted RETURNING post_id",
+				req.Content, commentID).Scan(&postID)
+			if err != nil {
+				if err == pgx.ErrNoRows {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "comment was deleted"})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			mentioned, err := syncMentions(c.Request.Context(), tx, mentionsOfComment, commentID, req.Content)
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
+
+			if err := tx.Commit(c.Request.Context()); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			r.notifyMentions(c, c.GetInt("user_id"), postID, &commentID, mentioned)
 			c.JSON(http.StatusOK, gin.H{})
 		})
 
@@ -134,13 +381,183 @@ func (r *RoutesManager) RegisterCommentsRoutes(router *gin.Engine) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment id"})
 				return
 			}
-			_, err = r.pgClient.Exec(c.Request.Context(), "DELETE FROM comments WHERE id = $1", commentID)
+			tx, err := r.pgClient.Begin(c.Request.Context())
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
+			defer tx.Rollback(c.Request.Context())
+
+			// The placeholder of a deleted comment has no text left to mention anyone
+			_, err = tx.Exec(c.Request.Context(), "DELETE FROM mentions WHERE comment_id = $1", commentID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			// Placeholders take no new likes or replies, so dropping one later leaves no notifications behind
+			if err := notifications.DeleteForComment(c.Request.Context(), tx, commentID); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			// A comment with replies is kept as a placeholder so the thread stays readable
+			tag, err := tx.Exec(c.Request.Context(), `
+				UPDATE comments SET is_deleted = TRUE, content = ''
+				WHERE id = $1 AND EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = comments.id)`, commentID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			if tag.RowsAffected() == 0 {
+				var parentID *int
+				err = tx.QueryRow(c.Request.Context(), "DELETE FROM comments WHERE id = $1 RETURNING parent_id", commentID).Scan(&parentID)
+				if err != nil && err != pgx.ErrNoRows {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				// Drop the parent placeholder once its last reply is gone
+				if parentID != nil {
+					_, err = tx.Exec(c.Request.Context(), `
+						DELETE FROM comments p
+						WHERE p.id = $1 AND p.is_deleted AND NOT EXISTS (SELECT 1 FROM comments r WHERE r.parent_id = p.id)`, *parentID)
+					if err != nil {
+						utils.LogError(c, err)
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+						return
+					}
+				}
+			}
+
+			if err := tx.Commit(c.Request.Context()); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{})
+		})
+
+		commentsRouter.POST(":comment_id/like", likeLimit, func(c *gin.Context) {
+			commentID, err := strconv.Atoi(c.Param("comment_id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment id"})
+				return
+			}
+			visible, err := r.canViewComment(c.Request.Context(), c.GetInt("user_id"), commentID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if !visible {
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+				return
+			}
+
+			likerID, exists := c.Get("user_id")
+			if !exists {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+				return
+			}
+
+			tag, err := r.pgClient.Exec(c.Request.Context(), `
+				INSERT INTO comments_likes (comment_id, user_id)
+				SELECT id, $2 FROM comments WHERE id = $1 AND NOT is_deleted`,
+				commentID, likerID)
+			if err != nil {
+				if utils.IsDuplicatePgxError(err) {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "you already liked this comment"})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if tag.RowsAffected() == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "comment not found"})
+				return
+			}
+
+			if event, err := r.commentLikeEvent(c.Request.Context(), commentID, likerID.(int)); err != nil {
+				utils.LogError(c, err)
+			} else {
+				r.notify(c, event)
+			}
+
+			c.JSON(http.StatusOK, gin.H{})
+		})
+
+		commentsRouter.DELETE(":comment_id/like", likeLimit, func(c *gin.Context) {
+			commentID, err := strconv.Atoi(c.Param("comment_id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid comment id"})
+				return
+			}
+
+			unlikerID, exists := c.Get("user_id")
+			if !exists {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
+				return
+			}
+
+			_, err = r.pgClient.Exec(c.Request.Context(),
+				"DELETE FROM comments_likes WHERE comment_id = $1 AND user_id = $2",
+				commentID, unlikerID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			if event, err := r.commentLikeEvent(c.Request.Context(), commentID, unlikerID.(int)); err == nil {
+				r.retractNotification(c, event)
+			} else if err != pgx.ErrNoRows {
+				utils.LogError(c, err)
+			}
+
 			c.JSON(http.StatusOK, gin.H{})
 		})
 	}
 }
+
+// insertComment stores a comment or reply together with its mentions and returns the mentioned users
+func (r *RoutesManager) insertComment(ctx context.Context, postID int, parentID *int, authorID int, content string) (int, []int, error) {
+	tx, err := r.pgClient.Begin(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var commentID int
+	err = tx.QueryRow(ctx,
+		"INSERT INTO comments (post_id, parent_id, author_id, content) VALUES ($1, $2, $3, $4) RETURNING id",
+		postID, parentID, authorID, content).Scan(&commentID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	mentioned, err := syncMentions(ctx, tx, mentionsOfComment, commentID, content)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, nil, err
+	}
+	return commentID, mentioned, nil
+}
+
+// commentLikeEvent describes a like of the comment for its author
+func (r *RoutesManager) commentLikeEvent(ctx context.Context, commentID, likerID int) (notifications.Event, error) {
+	event := notifications.Event{Type: notifications.TypeCommentLike, ActorID: likerID, CommentID: &commentID}
+	var postID int
+	err := r.pgClient.QueryRow(ctx, "SELECT author_id, post_id FROM comments WHERE id = $1", commentID).Scan(&event.RecipientID, &postID)
+	event.PostID = &postID
+	return event, err
+}
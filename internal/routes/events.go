@@ -0,0 +1,224 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"instagramplusbackend/auth"
+	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/realtime"
+	"instagramplusbackend/internal/utils"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxWatchedPosts bounds the post_id parameters of a single stream
+	maxWatchedPosts = 20
+	// keepAliveInterval keeps proxies from closing idle streams
+	keepAliveInterval = 25 * time.Second
+	// reconnectDelay is the retry hint sent to EventSource clients, in milliseconds
+	reconnectDelay = 3000
+)
+
+const (
+	EventNotification = "notification"
+	EventComment      = "comment"
+	EventLikes        = "likes"
+)
+
+func (r *RoutesManager) RegisterEventsRoutes(router *gin.Engine) {
+	eventsRouter := router.Group("/events")
+	eventsRouter.Use(r.middleware.RequireAuth())
+	{
+		// Server-Sent Events with the user's notifications and the comments and like counts of the watched posts,
+		// e.g. ?post_id=1&post_id=2. A Last-Event-ID header, or ?last_event_id= for the first connection, resumes a stream.
+		eventsRouter.GET("", func(c *gin.Context) {
+			userID := c.GetInt("user_id")
+
+			postIDs := c.QueryArray("post_id")
+			if len(postIDs) > maxWatchedPosts {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "too many posts"})
+				return
+			}
+			topics := []string{realtime.UserTopic(userID)}
+			for _, raw := range postIDs {
+				postID, err := strconv.Atoi(raw)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post id"})
+					return
+				}
+				visible, err := r.canViewPost(c.Request.Context(), userID, postID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				if !visible {
+					c.JSON(http.StatusNotFound, gin.H{"error": "post not found"})
+					return
+				}
+				topics = append(topics, realtime.PostTopic(postID))
+			}
+
+			lastEventID := c.GetHeader("Last-Event-ID")
+			if lastEventID == "" {
+				lastEventID = c.Query("last_event_id")
+			}
+			if lastEventID != "" && !realtime.ValidEventID(lastEventID) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid last event id"})
+				return
+			}
+
+			// Subscribing before the replay makes sure nothing published in between is lost
+			sub, err := r.realtime.Subscribe(c.Request.Context(), topics)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to subscribe"})
+				return
+			}
+			defer sub.Close()
+
+			replayed := []realtime.Event{}
+			if lastEventID != "" {
+				replayed, err = r.realtime.Replay(c.Request.Context(), topics, lastEventID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to resume"})
+					return
+				}
+			}
+
+			c.Header("Content-Type", "text/event-stream")
+			c.Header("Cache-Control", "no-cache")
+			c.Header("Connection", "keep-alive")
+			c.Header("X-Accel-Buffering", "no")
+			c.Status(http.StatusOK)
+			c.Render(-1, sse.Event{Event: "ready", Retry: reconnectDelay, Data: gin.H{"topics": topics}})
+			c.Writer.Flush()
+
+			// Live events already sent by the replay are skipped
+			lastSent := map[string]string{}
+			for _, event := range replayed {
+				if r.sendEvent(c, userID, event) {
+					lastSent[event.Topic] = event.ID
+				}
+			}
+			c.Writer.Flush()
+
+			keepAlive := time.NewTicker(keepAliveInterval)
+			defer keepAlive.Stop()
+			for {
+				select {
+				case <-c.Request.Context().Done():
+					return
+				case event, ok := <-sub.Events():
+					if !ok {
+						// The client fell behind, it reconnects and resumes from its last event
+						return
+					}
+					if last, found := lastSent[event.Topic]; found && realtime.CompareEventIDs(event.ID, last) <= 0 {
+						continue
+					}
+					r.sendEvent(c, userID, event)
+					c.Writer.Flush()
+				case <-keepAlive.C:
+					// A logout or a revoked session ends the stream, the reconnect is then refused
+					if !r.revalidate(c.Request.Context(), c) {
+						return
+					}
+					if _, err := c.Writer.WriteString(": keep-alive\n\n"); err != nil {
+						return
+					}
+					c.Writer.Flush()
+				}
+			}
+		})
+	}
+}
+
+// revalidate checks that the session cookie or API token a long-lived connection was opened with
+// still belongs to its user, RequireAuth only looked at it once
+func (r *RoutesManager) revalidate(ctx context.Context, c *gin.Context) bool {
+	userID := c.GetInt("user_id")
+	if _, usesToken := c.Get("token_scopes"); usesToken {
+		bearer := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		tokenUserID, _, err := r.auth.ValidateAPIToken(ctx, bearer)
+		if err != nil && err != auth.ErrInvalidToken {
+			utils.LogError(c, err)
+		}
+		return err == nil && tokenUserID == userID
+	}
+
+	token, err := c.Cookie("AUTH")
+	if err != nil {
+		return false
+	}
+	sessionUserID, err := r.auth.ValidateToken(ctx, token)
+	if err != nil && err != auth.ErrInvalidToken {
+		utils.LogError(c, err)
+	}
+	return err == nil && sessionUserID == strconv.Itoa(userID)
+}
+
+// sendEvent writes the event unless the viewer may not see it
+func (r *RoutesManager) sendEvent(c *gin.Context, viewerID int, event realtime.Event) bool {
+	visible, err := r.eventVisible(c.Request.Context(), viewerID, event)
+	if err != nil {
+		utils.LogError(c, err)
+		return false
+	}
+	if !visible {
+		return false
+	}
+
+	c.Render(-1, sse.Event{Id: event.ID, Event: event.Type, Data: event.Data})
+	return true
+}
+
+// eventVisible hides events the viewer may not see although they watch the topic. The post may have become hidden
+// since the subscription, e.g. its author blocked the viewer or went private, and comments of blocked or muted users
+// are left out like in the comment listings. Events on the viewer's own topic were filtered when they were recorded.
+func (r *RoutesManager) eventVisible(ctx context.Context, viewerID int, event realtime.Event) (bool, error) {
+	postID, isPostTopic := realtime.ParsePostTopic(event.Topic)
+	if !isPostTopic {
+		return true, nil
+	}
+	if event.Type != EventComment {
+		return r.canViewPost(ctx, viewerID, postID)
+	}
+
+	var comment models.CommentEvent
+	if err := json.Unmarshal(event.Data, &comment); err != nil {
+		return false, err
+	}
+	visible, err := r.canViewComment(ctx, viewerID, comment.ID)
+	if err != nil || !visible {
+		return false, err
+	}
+	muted, err := r.isMuted(ctx, viewerID, comment.AuthorID)
+	return !muted, err
+}
+
+// publish pushes a real-time event without failing the request, clients catch up on the next read
+func (r *RoutesManager) publish(c *gin.Context, topic, eventType string, data any) {
+	if err := r.realtime.Publish(c.Request.Context(), topic, eventType, data); err != nil {
+		utils.LogError(c, err)
+	}
+}
+
+// publishLikes pushes the current like count of the post to its watchers
+func (r *RoutesManager) publishLikes(c *gin.Context, postID int) {
+	event := models.LikesEvent{PostID: postID}
+	err := r.pgClient.QueryRow(c.Request.Context(), "SELECT COUNT(*) FROM posts_likes WHERE post_id = $1", postID).Scan(&event.LikesCount)
+	if err != nil {
+		utils.LogError(c, err)
+		return
+	}
+	r.publish(c, realtime.PostTopic(postID), EventLikes, event)
+}
@@ -0,0 +1,147 @@
+package routes
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"time"
+
+	"instagramplusbackend/internal/feed"
+	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// feedRange selects the posts created at or after Since, when set, and before the (Until, UntilID) position,
+// newest first. RankedAt anchors the recent comments signal.
+type feedRange struct {
+	Since    *time.Time
+	Until    time.Time
+	UntilID  int
+	Limit    int
+	RankedAt time.Time
+}
+
+// feedLoader loads the candidates of a feed in the range
+type feedLoader func(ctx context.Context, rng feedRange) ([]feed.Candidate, error)
+
+// serveFeed answers a page of a ranked feed. The candidates of the ranking window are scored together and
+// paged through in ranked order, older posts follow chronologically once they are exhausted.
+func (r *RoutesManager) serveFeed(c *gin.Context, load feedLoader) {
+	limit, err := utils.ParseLimit(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var cursor *feed.Cursor
+	if raw := c.Query("cursor"); raw != "" {
+		decoded, err := feed.DecodeCursor(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": utils.ErrInvalidPagination.Error()})
+			return
+		}
+		cursor = &decoded
+	}
+
+	rankedAt := r.ranker.Now()
+	if cursor != nil {
+		rankedAt = cursor.RankedAt
+	}
+	since, until := feed.CandidateRange(rankedAt)
+
+	// olderThan is where the chronological part of the feed continues
+	olderThan := feedRange{Until: since, UntilID: 0, RankedAt: rankedAt}
+	posts := []models.Post{}
+	var next *feed.Cursor
+	if cursor == nil || !cursor.Chronological {
+		candidates, err := load(c.Request.Context(), feedRange{Since: &since, Until: until, UntilID: math.MaxInt32, Limit: feed.MaxCandidates, RankedAt: rankedAt})
+		if err != nil {
+			utils.LogError(c, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if len(candidates) > 0 {
+			oldest := candidates[len(candidates)-1].Post
+			olderThan.Until, olderThan.UntilID = oldest.CreationTimestamp, oldest.ID
+		}
+
+		var more bool
+		posts, more = r.ranker.Page(candidates, rankedAt, cursor, limit)
+		if more {
+			last := posts[len(posts)-1]
+			next = &feed.Cursor{RankedAt: rankedAt, Score: last.Score, Timestamp: last.CreationTimestamp, ID: last.ID}
+		}
+	} else {
+		olderThan.Until, olderThan.UntilID = cursor.Timestamp, cursor.ID
+	}
+
+	if next == nil {
+		// One more post than fits tells whether the feed goes on
+		olderThan.Limit = limit - len(posts) + 1
+		older, err := load(c.Request.Context(), olderThan)
+		if err != nil {
+			utils.LogError(c, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+			return
+		}
+		if len(older) >= olderThan.Limit {
+			older = older[:olderThan.Limit-1]
+			next = &feed.Cursor{RankedAt: rankedAt, Chronological: true, Timestamp: olderThan.Until, ID: olderThan.UntilID}
+			if len(older) > 0 {
+				last := older[len(older)-1].Post
+				next.Timestamp, next.ID = last.CreationTimestamp, last.ID
+			}
+		}
+		posts = append(posts, r.ranker.Score(older, rankedAt)...)
+	}
+
+	if err := r.attachPostDetails(c.Request.Context(), posts); err != nil {
+		utils.LogError(c, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+		return
+	}
+	resp := models.PaginatedResponse[models.Post]{Items: posts}
+	if next != nil {
+		resp.NextCursor = feed.EncodeCursor(*next)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// loadFeedCandidates queries the candidates in the range from the posts selected by source, which starts with
+// a JOIN or WHERE clause. $1 is the viewer and extra arguments are numbered from $8 on.
+func (r *RoutesManager) loadFeedCandidates(ctx context.Context, viewerID int, source string, rng feedRange, extra ...any) ([]feed.Candidate, error) {
+	recentSince, recentUntil := feed.RecentCommentsRange(rng.RankedAt)
+	args := append([]any{viewerID, rng.Since, rng.Until, rng.UntilID, rng.Limit, recentSince, recentUntil}, extra...)
+	rows, err := r.pgClient.Query(ctx, `
+		SELECT p.id, u.username, p.image_url, p.description, p.creation_timestamp, up.name, up.surname, up.profile_image_url,
+		   (SELECT COUNT(*) FROM posts_likes l WHERE l.post_id = p.id) AS likes_count,
+		   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND NOT c.is_deleted) AS comments_count,
+		   EXISTS (SELECT 1 FROM posts_likes l WHERE l.post_id = p.id AND l.user_id = $1) AS user_liked,
+		   EXISTS (SELECT 1 FROM follows f WHERE f.profile_id = p.creator_id AND f.follower_id = $1) AS author_followed,
+		   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND NOT c.is_deleted AND c.creation_timestamp > $6 AND c.creation_timestamp <= $7) AS recent_comments
+		FROM posts p
+		JOIN users u ON p.creator_id = u.id
+		JOIN user_profiles up ON up.user_id = u.id`+source+`
+		  AND `+visibleToSQL("p.creator_id", "$1")+` AND `+notMutedSQL("p.creator_id", "$1")+`
+		  AND ($2 IS NULL OR p.creation_timestamp >= $2)
+		  AND (p.creation_timestamp, p.id) < ($3, $4)
+		ORDER BY p.creation_timestamp DESC, p.id DESC
+		LIMIT $5`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	candidates := []feed.Candidate{}
+	for rows.Next() {
+		candidate := feed.Candidate{Post: &models.Post{}}
+		post := candidate.Post
+		err := rows.Scan(&post.ID, &post.AuthorUsername, &post.ImageURL, &post.Description, &post.CreationTimestamp, &post.AuthorName, &post.AuthorSurname, &post.AuthorProfileImageURL, &post.LikesCount, &post.CommentsCount, &post.AlreadyLiked, &candidate.AuthorFollowed, &candidate.RecentComments)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, rows.Err()
+}
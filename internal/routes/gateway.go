@@ -0,0 +1,425 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"instagramplusbackend/auth"
+	"instagramplusbackend/internal/realtime"
+	"instagramplusbackend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// heartbeatInterval is how often the gateway pings the client and refreshes its presence
+	heartbeatInterval = 30 * time.Second
+	// pongWait closes connections that did not answer two heartbeats
+	pongWait = 2*heartbeatInterval + 10*time.Second
+	// maxGatewaySubscriptions bounds the topics of a single connection
+	maxGatewaySubscriptions = 50
+	// maxPresenceQuery bounds the users of a single presence query
+	maxPresenceQuery = 100
+	// sendBuffer is how many messages may wait for a slow client before it is disconnected
+	sendBuffer = 128
+	// maxGatewayMessage bounds a single message from the client
+	maxGatewayMessage = 64 * 1024
+	// writeWait is how long a write to the client may take
+	writeWait = 10 * time.Second
+)
+
+// gatewayUpgrader checks the origin of cookie-authenticated upgrades, on failure it answers with an HTTP error
+var gatewayUpgrader = websocket.Upgrader{CheckOrigin: sameOrigin}
+
+// gatewayRequest is a message from the client, ID is echoed in the reply so clients can match them.
+//
+//	{"type": "subscribe", "topic": "post:12", "last_event_id": "1700000000000-0"}
+//	{"type": "unsubscribe", "topic": "post:12"}
+//	{"type": "presence", "user_ids": [3, 4]}
+//	{"type": "ping"}
+//
+// Topics are "notifications" for the user's own notifications, "post:<id>" for the comments and
+// like counts of a post and "presence:<user id>" for when a user comes online or goes offline.
+type gatewayRequest struct {
+	ID          string `json:"id,omitempty"`
+	Type        string `json:"type"`
+	Topic       string `json:"topic,omitempty"`
+	LastEventID string `json:"last_event_id,omitempty"`
+	UserIDs     []int  `json:"user_ids,omitempty"`
+}
+
+// gatewayMessage is a message to the client, events carry the event name and the payload in Data
+type gatewayMessage struct {
+	ID       string          `json:"id,omitempty"`
+	Type     string          `json:"type"`
+	Topic    string          `json:"topic,omitempty"`
+	Event    string          `json:"event,omitempty"`
+	EventID  string          `json:"event_id,omitempty"`
+	Data     any             `json:"data,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Presence map[string]bool `json:"presence,omitempty"`
+}
+
+func (r *RoutesManager) RegisterGatewayRoutes(router *gin.Engine) {
+	gatewayRouter := router.Group("/ws")
+	// Browsers send the AUTH cookie with the upgrade request, API tokens are not accepted
+	gatewayRouter.Use(r.middleware.RequireAuth(), r.middleware.RequireSession())
+	{
+		gatewayRouter.GET("", r.serveGateway)
+	}
+}
+
+// serveGateway upgrades an authenticated request and serves the connection until either side closes it
+func (r *RoutesManager) serveGateway(c *gin.Context) {
+	conn, err := gatewayUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return
+	}
+	conn.SetReadLimit(maxGatewayMessage)
+
+	client := &gatewayClient{
+		routes:        r,
+		gin:           c,
+		conn:          conn,
+		userID:        c.GetInt("user_id"),
+		send:          make(chan gatewayMessage, sendBuffer),
+		subscriptions: map[string]*realtime.Subscription{},
+	}
+	client.run()
+}
+
+// sameOrigin rejects upgrades started by other sites, which would otherwise ride on the user's cookie.
+// Clients that are not browsers send no Origin header.
+func sameOrigin(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	return origin == "" || origin == auth.AppURL() || origin == "http://"+req.Host || origin == "https://"+req.Host
+}
+
+// gatewayClient is a single WebSocket connection. The handler goroutine reads, a writer goroutine
+// owns the outgoing side and every subscription forwards its events from its own goroutine.
+// Close frames are control messages, which any goroutine may write.
+type gatewayClient struct {
+	routes *RoutesManager
+	gin    *gin.Context
+	conn   *websocket.Conn
+	userID int
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	send   chan gatewayMessage
+
+	mu            sync.Mutex
+	subscriptions map[string]*realtime.Subscription
+	// forwarders is waited for before the handler returns, gin reuses its context afterwards
+	forwarders sync.WaitGroup
+}
+
+func (g *gatewayClient) run() {
+	g.ctx, g.cancel = context.WithCancel(g.gin.Request.Context())
+	defer g.cancel()
+
+	connectionID, err := realtime.NewConnectionID()
+	if err != nil {
+		utils.LogError(g.gin, err)
+		g.close(websocket.CloseGoingAway, "internal error")
+		return
+	}
+	hub := g.routes.realtime
+	if err := hub.Connect(g.ctx, g.userID, connectionID); err != nil {
+		utils.LogError(g.gin, err)
+	}
+	defer func() {
+		// The request context is gone by now
+		if err := hub.Disconnect(context.Background(), g.userID, connectionID); err != nil {
+			utils.LogError(g.gin, err)
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		g.writeLoop(connectionID)
+		close(done)
+	}()
+
+	g.readLoop()
+
+	g.cancel()
+	<-done
+	g.mu.Lock()
+	for topic, sub := range g.subscriptions {
+		sub.Close()
+		delete(g.subscriptions, topic)
+	}
+	g.mu.Unlock()
+	g.forwarders.Wait()
+	g.close(websocket.CloseNormalClosure, "")
+}
+
+// close sends a close frame and closes the connection, which ends a pending read
+func (g *gatewayClient) close(code int, reason string) {
+	g.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(writeWait))
+	g.conn.Close()
+}
+
+func (g *gatewayClient) readLoop() {
+	g.conn.SetReadDeadline(time.Now().Add(pongWait))
+	g.conn.SetPongHandler(func(string) error {
+		return g.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		messageType, data, err := g.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		g.conn.SetReadDeadline(time.Now().Add(pongWait))
+		if messageType != websocket.TextMessage {
+			g.reply(gatewayMessage{Type: "error", Error: "messages have to be JSON text"})
+			continue
+		}
+
+		var req gatewayRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			g.reply(gatewayMessage{Type: "error", Error: "invalid message"})
+			continue
+		}
+
+		switch req.Type {
+		case "subscribe":
+			g.subscribe(req)
+		case "unsubscribe":
+			g.unsubscribe(req)
+		case "presence":
+			g.presence(req)
+		case "ping":
+			g.reply(gatewayMessage{ID: req.ID, Type: "pong"})
+		default:
+			g.reply(gatewayMessage{ID: req.ID, Type: "error", Error: "unknown message type"})
+		}
+	}
+}
+
+func (g *gatewayClient) writeLoop(connectionID string) {
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-g.ctx.Done():
+			return
+		case message := <-g.send:
+			encoded, err := json.Marshal(message)
+			if err != nil {
+				utils.LogError(g.gin, err)
+				continue
+			}
+			g.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := g.conn.WriteMessage(websocket.TextMessage, encoded); err != nil {
+				g.cancel()
+				return
+			}
+		case <-heartbeat.C:
+			if !g.routes.revalidate(g.ctx, g.gin) {
+				g.close(websocket.ClosePolicyViolation, "session ended")
+				g.cancel()
+				return
+			}
+			if err := g.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				g.cancel()
+				return
+			}
+			if err := g.routes.realtime.Heartbeat(g.ctx, g.userID, connectionID); err != nil {
+				utils.LogError(g.gin, err)
+			}
+		}
+	}
+}
+
+// reply queues a message, a client that does not keep up is disconnected and resumes after reconnecting
+func (g *gatewayClient) reply(message gatewayMessage) {
+	select {
+	case g.send <- message:
+	case <-g.ctx.Done():
+	default:
+		g.close(websocket.CloseGoingAway, "too slow")
+		g.cancel()
+	}
+}
+
+// resolveTopic maps a client topic to the hub topic after checking that the user may watch it
+func (g *gatewayClient) resolveTopic(topic string) (string, string) {
+	if topic == "notifications" {
+		return realtime.UserTopic(g.userID), ""
+	}
+
+	kind, rawID, found := strings.Cut(topic, ":")
+	id, err := strconv.Atoi(rawID)
+	if !found || err != nil {
+		return "", "unknown topic"
+	}
+
+	var visible bool
+	switch kind {
+	case "post":
+		visible, err = g.routes.canViewPost(g.ctx, g.userID, id)
+	case "presence":
+		visible = id == g.userID
+		if !visible {
+			visible, err = g.routes.canViewUser(g.ctx, g.userID, id)
+		}
+	default:
+		return "", "unknown topic"
+	}
+	if err != nil {
+		utils.LogError(g.gin, err)
+		return "", "database error"
+	}
+	if !visible {
+		return "", "topic not found"
+	}
+
+	if kind == "post" {
+		return realtime.PostTopic(id), ""
+	}
+	return realtime.PresenceTopic(id), ""
+}
+
+func (g *gatewayClient) subscribe(req gatewayRequest) {
+	if req.LastEventID != "" && !realtime.ValidEventID(req.LastEventID) {
+		g.reply(gatewayMessage{ID: req.ID, Type: "error", Topic: req.Topic, Error: "invalid last event id"})
+		return
+	}
+
+	g.mu.Lock()
+	_, subscribed := g.subscriptions[req.Topic]
+	full := len(g.subscriptions) >= maxGatewaySubscriptions
+	g.mu.Unlock()
+	if subscribed {
+		g.reply(gatewayMessage{ID: req.ID, Type: "subscribed", Topic: req.Topic})
+		return
+	}
+	if full {
+		g.reply(gatewayMessage{ID: req.ID, Type: "error", Topic: req.Topic, Error: "too many subscriptions"})
+		return
+	}
+
+	hubTopic, problem := g.resolveTopic(req.Topic)
+	if problem != "" {
+		g.reply(gatewayMessage{ID: req.ID, Type: "error", Topic: req.Topic, Error: problem})
+		return
+	}
+
+	// Subscribing before the replay makes sure nothing published in between is lost
+	sub, err := g.routes.realtime.Subscribe(g.ctx, []string{hubTopic})
+	if err != nil {
+		utils.LogError(g.gin, err)
+		g.reply(gatewayMessage{ID: req.ID, Type: "error", Topic: req.Topic, Error: "failed to subscribe"})
+		return
+	}
+	replayed := []realtime.Event{}
+	if req.LastEventID != "" {
+		replayed, err = g.routes.realtime.Replay(g.ctx, []string{hubTopic}, req.LastEventID)
+		if err != nil {
+			sub.Close()
+			utils.LogError(g.gin, err)
+			g.reply(gatewayMessage{ID: req.ID, Type: "error", Topic: req.Topic, Error: "failed to resume"})
+			return
+		}
+	}
+
+	g.mu.Lock()
+	g.subscriptions[req.Topic] = sub
+	g.mu.Unlock()
+	g.reply(gatewayMessage{ID: req.ID, Type: "subscribed", Topic: req.Topic})
+
+	g.forwarders.Add(1)
+	go func() {
+		defer g.forwarders.Done()
+		g.forward(req.Topic, sub, replayed)
+	}()
+}
+
+// forward relays the events of a subscription, starting with the replayed ones
+func (g *gatewayClient) forward(topic string, sub *realtime.Subscription, replayed []realtime.Event) {
+	lastSent := ""
+	for _, event := range replayed {
+		g.sendEvent(topic, event)
+		lastSent = event.ID
+	}
+
+	for event := range sub.Events() {
+		if lastSent != "" && realtime.CompareEventIDs(event.ID, lastSent) <= 0 {
+			continue
+		}
+		g.sendEvent(topic, event)
+	}
+
+	// The channel also closes when the hub dropped a lagging subscription, the client resubscribes to resume
+	g.mu.Lock()
+	dropped := g.subscriptions[topic] == sub
+	if dropped {
+		delete(g.subscriptions, topic)
+	}
+	g.mu.Unlock()
+	if dropped && g.ctx.Err() == nil {
+		g.reply(gatewayMessage{Type: "unsubscribed", Topic: topic, Error: "subscription fell behind"})
+	}
+}
+
+func (g *gatewayClient) sendEvent(topic string, event realtime.Event) {
+	visible, err := g.routes.eventVisible(g.ctx, g.userID, event)
+	if err != nil {
+		utils.LogError(g.gin, err)
+		return
+	}
+	if visible {
+		g.reply(gatewayMessage{Type: "event", Topic: topic, Event: event.Type, EventID: event.ID, Data: event.Data})
+	}
+}
+
+func (g *gatewayClient) unsubscribe(req gatewayRequest) {
+	g.mu.Lock()
+	sub, found := g.subscriptions[req.Topic]
+	delete(g.subscriptions, req.Topic)
+	g.mu.Unlock()
+
+	if found {
+		sub.Close()
+	}
+	g.reply(gatewayMessage{ID: req.ID, Type: "unsubscribed", Topic: req.Topic})
+}
+
+// presence answers which of the users are online, users hidden from the client are reported offline
+func (g *gatewayClient) presence(req gatewayRequest) {
+	if len(req.UserIDs) > maxPresenceQuery {
+		g.reply(gatewayMessage{ID: req.ID, Type: "error", Error: "too many users"})
+		return
+	}
+
+	online, err := g.routes.realtime.Online(g.ctx, req.UserIDs)
+	if err != nil {
+		utils.LogError(g.gin, err)
+		g.reply(gatewayMessage{ID: req.ID, Type: "error", Error: "failed to load presence"})
+		return
+	}
+
+	presence := map[string]bool{}
+	for _, userID := range req.UserIDs {
+		// Offline users are reported as such without looking up whether the client may see them
+		visible := userID == g.userID || !online[userID]
+		if !visible {
+			visible, err = g.routes.canViewUser(g.ctx, g.userID, userID)
+			if err != nil {
+				utils.LogError(g.gin, err)
+			}
+		}
+		presence[strconv.Itoa(userID)] = online[userID] && visible
+	}
+	g.reply(gatewayMessage{ID: req.ID, Type: "presence", Presence: presence})
+}
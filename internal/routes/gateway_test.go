@@ -0,0 +1,180 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"instagramplusbackend/internal/realtime"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
+)
+
+const testGatewayUser = 7
+
+// newTestGateway serves the gateway to testGatewayUser without the session middleware.
+// Only topics that need no database, such as "notifications", can be used.
+func newTestGateway(t *testing.T) (*httptest.Server, *realtime.Hub, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	r := &RoutesManager{redisClient: client, realtime: realtime.NewHub(client)}
+
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/ws", func(c *gin.Context) { c.Set("user_id", testGatewayUser) }, r.serveGateway)
+	httpServer := httptest.NewServer(engine)
+	t.Cleanup(httpServer.Close)
+	return httpServer, r.realtime, server
+}
+
+type testGatewayConn struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func gatewayURL(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+}
+
+func dialGateway(t *testing.T, server *httptest.Server) *testGatewayConn {
+	conn, _, err := websocket.DefaultDialer.Dial(gatewayURL(server), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return &testGatewayConn{t: t, conn: conn}
+}
+
+func (g *testGatewayConn) send(req gatewayRequest) {
+	if err := g.conn.WriteJSON(req); err != nil {
+		g.t.Fatal(err)
+	}
+}
+
+// receive reads the next gateway message
+func (g *testGatewayConn) receive() gatewayMessage {
+	g.t.Helper()
+	var message gatewayMessage
+	if err := g.conn.ReadJSON(&message); err != nil {
+		g.t.Fatal(err)
+	}
+	return message
+}
+
+func (g *testGatewayConn) expect(wantType, wantID string) gatewayMessage {
+	g.t.Helper()
+	message := g.receive()
+	if message.Type != wantType || message.ID != wantID {
+		g.t.Fatalf("got %+v, want a %q message with id %q", message, wantType, wantID)
+	}
+	return message
+}
+
+// waitForSubscribers waits until the hub listens on the Redis channel of the topic, events published
+// earlier would only be found by a replay
+func waitForSubscribers(t *testing.T, server *miniredis.Miniredis, topic string, want int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if server.PubSubNumSub("realtime:" + topic)["realtime:"+topic] == want {
+			return
+		}
+	}
+	t.Fatalf("the hub did not reach %d subscribers of %s", want, topic)
+}
+
+func TestGatewaySubscribeReplayUnsubscribe(t *testing.T) {
+	server, hub, redisServer := newTestGateway(t)
+	ctx := context.Background()
+	topic := realtime.UserTopic(testGatewayUser)
+
+	for i := 1; i <= 2; i++ {
+		if err := hub.Publish(ctx, topic, EventNotification, map[string]int{"id": i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	published, err := hub.Replay(ctx, []string{topic}, "0-0")
+	if err != nil || len(published) != 2 {
+		t.Fatalf("published events = %v, %v", published, err)
+	}
+
+	conn := dialGateway(t, server)
+	conn.send(gatewayRequest{ID: "1", Type: "subscribe", Topic: "notifications", LastEventID: published[0].ID})
+	conn.expect("subscribed", "1")
+
+	// Only the events after the given one are replayed
+	replayed := conn.expect("event", "")
+	if replayed.Topic != "notifications" || replayed.Event != EventNotification || replayed.EventID != published[1].ID {
+		t.Fatalf("replayed %+v, want event %s", replayed, published[1].ID)
+	}
+
+	waitForSubscribers(t, redisServer, topic, 1)
+	if err := hub.Publish(ctx, topic, EventNotification, map[string]int{"id": 3}); err != nil {
+		t.Fatal(err)
+	}
+	live := conn.expect("event", "")
+	if data, _ := live.Data.(map[string]any); data["id"] != 3.0 || realtime.CompareEventIDs(live.EventID, published[1].ID) <= 0 {
+		t.Fatalf("live event %+v", live)
+	}
+
+	conn.send(gatewayRequest{ID: "2", Type: "unsubscribe", Topic: "notifications"})
+	conn.expect("unsubscribed", "2")
+	if err := hub.Publish(ctx, topic, EventNotification, map[string]int{"id": 4}); err != nil {
+		t.Fatal(err)
+	}
+	// The pong comes first, no event is sent after unsubscribing
+	conn.send(gatewayRequest{ID: "3", Type: "ping"})
+	conn.expect("pong", "3")
+	waitForSubscribers(t, redisServer, topic, 0)
+}
+
+func TestGatewayRejectsInvalidRequests(t *testing.T) {
+	server, _, _ := newTestGateway(t)
+	conn := dialGateway(t, server)
+
+	tests := []struct {
+		req       gatewayRequest
+		wantError string
+	}{
+		{gatewayRequest{ID: "1", Type: "subscribe", Topic: "everything"}, "unknown topic"},
+		{gatewayRequest{ID: "2", Type: "subscribe", Topic: "post:abc"}, "unknown topic"},
+		{gatewayRequest{ID: "3", Type: "subscribe", Topic: "notifications", LastEventID: "latest"}, "invalid last event id"},
+		{gatewayRequest{ID: "4", Type: "shout"}, "unknown message type"},
+		{gatewayRequest{ID: "5", Type: "presence", UserIDs: make([]int, maxPresenceQuery+1)}, "too many users"},
+	}
+	for _, test := range tests {
+		conn.send(test.req)
+		if message := conn.expect("error", test.req.ID); message.Error != test.wantError {
+			t.Errorf("%+v: error %q, want %q", test.req, message.Error, test.wantError)
+		}
+	}
+}
+
+func TestGatewayRejectsOtherOrigins(t *testing.T) {
+	server, _, _ := newTestGateway(t)
+
+	header := http.Header{"Origin": {"https://attacker.example"}}
+	conn, response, err := websocket.DefaultDialer.Dial(gatewayURL(server), header)
+	if err == nil {
+		conn.Close()
+		t.Fatal("upgrade from another site was accepted")
+	}
+	if response == nil || response.StatusCode != http.StatusForbidden {
+		t.Fatalf("response %v, want status %d", response, http.StatusForbidden)
+	}
+
+	// The page's own origin is accepted
+	header.Set("Origin", server.URL)
+	conn, _, err = websocket.DefaultDialer.Dial(gatewayURL(server), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
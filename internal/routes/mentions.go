@@ -0,0 +1,173 @@
+package routes
+
+import (
+	"context"
+	"strings"
+
+	"instagramplusbackend/internal/entities"
+	"instagramplusbackend/internal/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Mentions belong either to a post description or to a comment, the constants name the owning column
+const (
+	mentionsOfPost    = "post_id"
+	mentionsOfComment = "comment_id"
+)
+
+// syncMentions replaces the mentions of a post or comment with the ones in its text and returns the users
+// that were not mentioned before. Usernames that do not resolve to a user are left as plain text.
+func syncMentions(ctx context.Context, tx pgx.Tx, column string, id int, text string) ([]int, error) {
+	rows, err := tx.Query(ctx, "DELETE FROM mentions WHERE "+column+" = $1 RETURNING user_id", id)
+	if err != nil {
+		return nil, err
+	}
+	alreadyMentioned := map[int]bool{}
+	for rows.Next() {
+		var userID int
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		alreadyMentioned[userID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	mentions := entities.ExtractMentions(text)
+	if len(mentions) == 0 {
+		return nil, nil
+	}
+
+	usernames := make([]string, len(mentions))
+	for i, mention := range mentions {
+		usernames[i] = strings.ToLower(mention.Username)
+	}
+	rows, err = tx.Query(ctx, "SELECT id, LOWER(username) FROM users WHERE LOWER(username) = ANY($1)", usernames)
+	if err != nil {
+		return nil, err
+	}
+	userIDs := map[string]int{}
+	for rows.Next() {
+		var userID int
+		var username string
+		if err := rows.Scan(&userID, &username); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		userIDs[username] = userID
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var resolvedIDs, offsets, lengths, newlyMentioned []int
+	for i, mention := range mentions {
+		userID, ok := userIDs[usernames[i]]
+		if !ok {
+			continue
+		}
+		resolvedIDs = append(resolvedIDs, userID)
+		offsets = append(offsets, mention.Offset)
+		lengths = append(lengths, mention.Length)
+		if !alreadyMentioned[userID] {
+			alreadyMentioned[userID] = true
+			newlyMentioned = append(newlyMentioned, userID)
+		}
+	}
+	if len(resolvedIDs) == 0 {
+		return nil, nil
+	}
+
+	_, err = tx.Exec(ctx, `
+		INSERT INTO mentions (`+column+`, user_id, start_offset, length)
+		SELECT $1, UNNEST($2::int[]), UNNEST($3::int[]), UNNEST($4::int[])`, id, resolvedIDs, offsets, lengths)
+	if err != nil {
+		return nil, err
+	}
+	return newlyMentioned, nil
+}
+
+// loadMentions returns the resolved mentions of the given posts or comments keyed by their id
+func (r *RoutesManager) loadMentions(ctx context.Context, column string, ids []int) (map[int][]models.Mention, error) {
+	rows, err := r.pgClient.Query(ctx, `
+		SELECT m.`+column+`, m.user_id, u.username, m.start_offset, m.length
+		FROM mentions m
+		JOIN users u ON u.id = m.user_id
+		WHERE m.`+column+` = ANY($1)
+		ORDER BY m.start_offset`, ids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mentions := map[int][]models.Mention{}
+	for rows.Next() {
+		var id int
+		var mention models.Mention
+		if err := rows.Scan(&id, &mention.UserID, &mention.Username, &mention.Offset, &mention.Length); err != nil {
+			return nil, err
+		}
+		mentions[id] = append(mentions[id], mention)
+	}
+	return mentions, rows.Err()
+}
+
+// attachPostDetails loads everything a post response carries besides its own row
+func (r *RoutesManager) attachPostDetails(ctx context.Context, posts []models.Post) error {
+	if err := r.attachPostMedia(ctx, posts); err != nil {
+		return err
+	}
+	return r.attachPostMentions(ctx, posts)
+}
+
+func (r *RoutesManager) attachPostMentions(ctx context.Context, posts []models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	postIDs := make([]int, len(posts))
+	for i, post := range posts {
+		postIDs[i] = post.ID
+	}
+	mentions, err := r.loadMentions(ctx, mentionsOfPost, postIDs)
+	if err != nil {
+		return err
+	}
+
+	for i := range posts {
+		posts[i].Mentions = mentions[posts[i].ID]
+		if posts[i].Mentions == nil {
+			posts[i].Mentions = []models.Mention{}
+		}
+	}
+	return nil
+}
+
+// attachCommentMentions loads the mentions of the given comments, deleted comments keep none
+func (r *RoutesManager) attachCommentMentions(ctx context.Context, comments []models.Comment) error {
+	if len(comments) == 0 {
+		return nil
+	}
+
+	commentIDs := make([]int, len(comments))
+	for i, comment := range comments {
+		commentIDs[i] = comment.ID
+	}
+	mentions, err := r.loadMentions(ctx, mentionsOfComment, commentIDs)
+	if err != nil {
+		return err
+	}
+
+	for i := range comments {
+		comments[i].Mentions = mentions[comments[i].ID]
+		if comments[i].Mentions == nil || comments[i].IsDeleted {
+			comments[i].Mentions = []models.Mention{}
+		}
+	}
+	return nil
+}
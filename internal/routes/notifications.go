@@ -0,0 +1,229 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/notifications"
+	"instagramplusbackend/internal/realtime"
+	"instagramplusbackend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// notificationActorsShown is how many actors of a group are returned by name
+const notificationActorsShown = 3
+
+func (r *RoutesManager) RegisterNotificationsRoutes(router *gin.Engine) {
+	notificationsRouter := router.Group("/notifications")
+	notificationsRouter.Use(r.middleware.RequireAuth())
+	{
+		// Newest first, ?unread=true returns only unread notifications
+		notificationsRouter.GET("", func(c *gin.Context) {
+			page, err := utils.ParsePagination(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursorTimestamp, cursorID := page.CursorArgs()
+
+			rows, err := r.pgClient.Query(c.Request.Context(), `
+				SELECT n.id, n.type, n.post_id, n.comment_id, n.is_read, n.creation_timestamp,
+				   (SELECT COUNT(*) FROM notification_actors a WHERE a.notification_id = n.id) AS actors_count
+				FROM notifications n
+				WHERE n.user_id = $1 AND (NOT $2 OR NOT n.is_read)
+				  AND ($3 IS NULL OR (n.creation_timestamp, n.id) < ($3, $4))
+				ORDER BY n.creation_timestamp DESC, n.id DESC
+				LIMIT $5`, c.GetInt("user_id"), c.Query("unread") == "true", cursorTimestamp, cursorID, page.FetchLimit())
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer rows.Close()
+
+			items := []models.Notification{}
+			for rows.Next() {
+				var notification models.Notification
+				err := rows.Scan(&notification.ID, &notification.Type, &notification.PostID, &notification.CommentID, &notification.IsRead, &notification.CreationTimestamp, &notification.ActorsCount)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				items = append(items, notification)
+			}
+			if err := rows.Err(); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			resp := utils.Paginate(items, page, models.Notification.CursorKey)
+			if err := r.attachNotificationActors(c.Request.Context(), resp.Items); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, resp)
+		})
+
+		notificationsRouter.GET("/unread-count", func(c *gin.Context) {
+			var count int
+			err := r.pgClient.QueryRow(c.Request.Context(),
+				"SELECT COUNT(*) FROM notifications WHERE user_id = $1 AND NOT is_read",
+				c.GetInt("user_id")).Scan(&count)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"unread_count": count})
+		})
+
+		notificationsRouter.POST("/:notification_id/read", func(c *gin.Context) {
+			notificationID, err := strconv.Atoi(c.Param("notification_id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid notification id"})
+				return
+			}
+
+			tag, err := r.pgClient.Exec(c.Request.Context(),
+				"UPDATE notifications SET is_read = TRUE WHERE id = $1 AND user_id = $2",
+				notificationID, c.GetInt("user_id"))
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if tag.RowsAffected() == 0 {
+				c.JSON(http.StatusNotFound, gin.H{"error": "notification not found"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{})
+		})
+
+		notificationsRouter.POST("/read-all", func(c *gin.Context) {
+			_, err := r.pgClient.Exec(c.Request.Context(),
+				"UPDATE notifications SET is_read = TRUE WHERE user_id = $1 AND NOT is_read",
+				c.GetInt("user_id"))
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{})
+		})
+	}
+}
+
+// attachNotificationActors loads the most recent actors of each notification and renders its text
+func (r *RoutesManager) attachNotificationActors(ctx context.Context, items []models.Notification) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	notificationIDs := make([]int, len(items))
+	for i, notification := range items {
+		notificationIDs[i] = notification.ID
+	}
+
+	rows, err := r.pgClient.Query(ctx, `
+		SELECT a.notification_id, u.id, u.username, up.profile_image_url
+		FROM (
+			SELECT notification_id, actor_id,
+			   ROW_NUMBER() OVER (PARTITION BY notification_id ORDER BY creation_timestamp DESC) AS position
+			FROM notification_actors
+			WHERE notification_id = ANY($1)
+		) a
+		JOIN users u ON u.id = a.actor_id
+		JOIN user_profiles up ON up.user_id = u.id
+		WHERE a.position <= $2
+		ORDER BY a.notification_id, a.position`, notificationIDs, notificationActorsShown)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	actors := map[int][]models.NotificationActor{}
+	for rows.Next() {
+		var notificationID int
+		var actor models.NotificationActor
+		if err := rows.Scan(&notificationID, &actor.UserID, &actor.Username, &actor.ProfileImageURL); err != nil {
+			return err
+		}
+		actors[notificationID] = append(actors[notificationID], actor)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range items {
+		items[i].Actors = actors[items[i].ID]
+		if items[i].Actors == nil {
+			items[i].Actors = []models.NotificationActor{}
+		}
+		usernames := make([]string, len(items[i].Actors))
+		for j, actor := range items[i].Actors {
+			usernames[j] = actor.Username
+		}
+		items[i].Text = notifications.Text(notifications.Type(items[i].Type), usernames, items[i].ActorsCount)
+	}
+	return nil
+}
+
+// notify records a notification and pushes it to the recipient without failing the request,
+// notifications are best effort like the timelines
+func (r *RoutesManager) notify(c *gin.Context, e notifications.Event) {
+	notificationID, err := r.notifications.Notify(c.Request.Context(), e)
+	if err != nil {
+		utils.LogError(c, err)
+		return
+	}
+	if notificationID == 0 {
+		return
+	}
+	r.publish(c, realtime.UserTopic(e.RecipientID), EventNotification, models.NotificationEvent{
+		ID:        notificationID,
+		Type:      string(e.Type),
+		ActorID:   e.ActorID,
+		PostID:    e.PostID,
+		CommentID: e.CommentID,
+	})
+}
+
+func (r *RoutesManager) retractNotification(c *gin.Context, e notifications.Event) {
+	if err := r.notifications.Retract(c.Request.Context(), e); err != nil {
+		utils.LogError(c, err)
+	}
+}
+
+// notifyMentions tells newly mentioned users about a post, or about a comment when commentID is set.
+// Users who cannot see the post, such as non-followers of a private account, are skipped.
+func (r *RoutesManager) notifyMentions(c *gin.Context, actorID, postID int, commentID *int, userIDs []int) {
+	for _, userID := range userIDs {
+		visible, err := r.canViewPost(c.Request.Context(), userID, postID)
+		if err != nil {
+			utils.LogError(c, err)
+			continue
+		}
+		if !visible {
+			continue
+		}
+		r.notify(c, notifications.Event{Type: notifications.TypeMention, RecipientID: userID, ActorID: actorID, PostID: &postID, CommentID: commentID})
+	}
+}
+
+func (r *RoutesManager) postAuthorID(ctx context.Context, postID int) (int, error) {
+	var authorID int
+	err := r.pgClient.QueryRow(ctx, "SELECT creator_id FROM posts WHERE id = $1", postID).Scan(&authorID)
+	return authorID, err
+}
+
+func (r *RoutesManager) commentAuthorID(ctx context.Context, commentID int) (int, error) {
+	var authorID int
+	err := r.pgClient.QueryRow(ctx, "SELECT author_id FROM comments WHERE id = $1", commentID).Scan(&authorID)
+	return authorID, err
+}
@@ -1,20 +1,30 @@
 package routes
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
+	"time"
 
+	"instagramplusbackend/internal/entities"
+	"instagramplusbackend/internal/feed"
+	"instagramplusbackend/internal/middleware"
 	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/notifications"
 	"instagramplusbackend/internal/utils"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/jackc/pgx/v5"
 )
 
 func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 	postRouter := router.Group("/posts")
 	postRouter.Use(r.middleware.RequireAuth())
+
+	createLimit := r.middleware.RateLimit(middleware.RateLimitPolicy{Name: "posts:create", Limit: 10, Period: time.Hour, PremiumLimit: 30})
+	likeLimit := r.middleware.RateLimit(middleware.RateLimitPolicy{Name: "posts:like", Limit: 120, Period: time.Hour, PremiumLimit: 300})
 	{
 		postRouter.GET("", func(c *gin.Context) {
 			userID, exists := c.Get("user_id")
@@ -23,39 +33,13 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				return
 			}
 
-			rows, err := r.pgClient.Query(c.Request.Context(), `
-				SELECT p.id, u.username, p.image_url, p.description, p.creation_timestamp, up.name, up.surname, up.profile_image_url,
-				   (SELECT COUNT(*) FROM posts_likes l WHERE l.post_id = p.id) AS likes_count,
-				   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
-				   EXISTS (SELECT 1 FROM posts_likes l WHERE l.post_id = p.id AND l.user_id = $1) AS user_liked
-				FROM posts p
-				JOIN users u ON p.creator_id = u.id
-				JOIN user_profiles up ON up.user_id = u.id
-				WHERE p.creator_id != $1
-				ORDER BY p.creation_timestamp DESC`, userID)
-			if err != nil {
-				utils.LogError(c, err)
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
-				return
-			}
-			defer rows.Close()
-
-			posts := []models.Post{}
-			for rows.Next() {
-				var post models.Post
-				err := rows.Scan(&post.ID, &post.AuthorUsername, &post.ImageURL, &post.Description, &post.CreationTimestamp, &post.AuthorName, &post.AuthorSurname, &post.AuthorProfileImageURL, &post.LikesCount, &post.CommentsCount, &post.AlreadyLiked)
-				if err != nil {
-					utils.LogError(c, err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
-					return
-				}
-				posts = append(posts, post)
-			}
-
-			c.JSON(http.StatusOK, posts)
+			r.serveFeed(c, func(ctx context.Context, rng feedRange) ([]feed.Candidate, error) {
+				return r.loadFeedCandidates(ctx, userID.(int), `
+				WHERE p.creator_id != $1`, rng)
+			})
 		})
 
-		postRouter.POST("", func(c *gin.Context) {
+		postRouter.POST("", createLimit, r.middleware.RequireVerifiedEmail(middleware.ActionPost), func(c *gin.Context) {
 			var req models.AddPostRequest
 			data := c.Request.FormValue("data")
 			if err := json.Unmarshal([]byte(data), &req); err != nil {
@@ -63,22 +47,37 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				return
 			}
 
-			imageURL, err := utils.UploadPostImage(c)
+			if err := binding.Validator.ValidateStruct(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+				return
+			}
+
+			images, err := utils.UploadPostImages(c, r.media)
 			if err != nil {
 				utils.LogError(c, err)
+				if err == utils.ErrTooManyImages {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "a post can have at most 10 images"})
+					return
+				}
 				c.JSON(http.StatusBadRequest, gin.H{"error": "failed to upload image"})
 				return
 			}
 
-			_, err = r.pgClient.Exec(c.Request.Context(),
-				"INSERT INTO posts (image_url, description, creator_id) VALUES ($1, $2, $3)",
-				imageURL, req.Description, c.GetInt("user_id"))
+			postID, createdAt, mentioned, err := r.insertPost(c.Request.Context(), c.GetInt("user_id"), req, images)
 			if err != nil {
 				utils.LogError(c, err)
+				if err := utils.RemovePostImages(c.Request.Context(), r.media, images); err != nil {
+					utils.LogError(c, err)
+				}
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
 
+			if err := r.timeline.PushPost(c.Request.Context(), c.GetInt("user_id"), postID, createdAt); err != nil {
+				utils.LogError(c, err)
+			}
+			r.notifyMentions(c, c.GetInt("user_id"), postID, nil, mentioned)
+
 			c.JSON(http.StatusOK, gin.H{})
 		})
 
@@ -92,12 +91,12 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 			row := r.pgClient.QueryRow(c.Request.Context(), `
 				SELECT p.id, u.username, p.image_url, p.description, p.creation_timestamp, up.name, up.surname, up.profile_image_url,
 					(SELECT COUNT(*) FROM posts_likes l WHERE l.post_id = p.id) AS likes_count,
-					(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
+					(SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND NOT c.is_deleted) AS comments_count,
 					EXISTS (SELECT 1 FROM posts_likes l WHERE l.post_id = p.id AND l.user_id = $1) AS user_liked
 				FROM posts p
 				JOIN users u ON p.creator_id = u.id
 				JOIN user_profiles up ON up.user_id = u.id
-				WHERE p.id = $2`, c.GetInt("user_id"), postID)
+				WHERE p.id = $2 AND `+visibleToSQL("p.creator_id", "$1"), c.GetInt("user_id"), postID)
 
 			var post models.Post
 			err := row.Scan(&post.ID, &post.AuthorUsername, &post.ImageURL, &post.Description, &post.CreationTimestamp, &post.AuthorName, &post.AuthorSurname, &post.AuthorProfileImageURL, &post.LikesCount, &post.CommentsCount, &post.AlreadyLiked)
@@ -111,7 +110,14 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				return
 			}
 
-			c.JSON(http.StatusOK, post)
+			posts := []models.Post{post}
+			if err := r.attachPostDetails(c.Request.Context(), posts); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			c.JSON(http.StatusOK, posts[0])
 		})
 
 		postRouter.GET("/user/:username", func(c *gin.Context) {
@@ -121,16 +127,47 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				return
 			}
 
+			var ownerID int
+			err := r.pgClient.QueryRow(c.Request.Context(), `SELECT id FROM users WHERE username = $1`, username).Scan(&ownerID)
+			if err != nil {
+				if err == pgx.ErrNoRows {
+					c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			visible, err := r.canViewUser(c.Request.Context(), c.GetInt("user_id"), ownerID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if !visible {
+				c.JSON(http.StatusForbidden, gin.H{"error": "this account is private"})
+				return
+			}
+
+			page, err := utils.ParsePagination(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursorTimestamp, cursorID := page.CursorArgs()
+
 			rows, err := r.pgClient.Query(c.Request.Context(), `
 				SELECT p.id, u.username, p.image_url, p.description, p.creation_timestamp, up.name, up.surname, up.profile_image_url,
 				   (SELECT COUNT(*) FROM posts_likes l WHERE l.post_id = p.id) AS likes_count,
-				   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
+				   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND NOT c.is_deleted) AS comments_count,
 				   EXISTS (SELECT 1 FROM posts_likes l WHERE l.post_id = p.id AND l.user_id = $1) AS user_liked
 				FROM posts p
 				JOIN users u ON p.creator_id = u.id
 				JOIN user_profiles up ON up.user_id = u.id
 				WHERE u.username = $2
-				ORDER BY p.creation_timestamp DESC`, c.GetInt("user_id"), username)
+				  AND ($3 IS NULL OR (p.creation_timestamp, p.id) < ($3, $4))
+				ORDER BY p.creation_timestamp DESC, p.id DESC
+				LIMIT $5`, c.GetInt("user_id"), username, cursorTimestamp, cursorID, page.FetchLimit())
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
@@ -153,11 +190,17 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
-			if len(posts) == 0 {
+			if len(posts) == 0 && page.Cursor == nil {
 				c.JSON(http.StatusNotFound, gin.H{"error": "no posts found"})
 				return
 			}
-			c.JSON(http.StatusOK, posts)
+			resp := utils.Paginate(posts, page, models.Post.CursorKey)
+			if err := r.attachPostDetails(c.Request.Context(), resp.Items); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, resp)
 		})
 
 		postRouter.GET("/followed", func(c *gin.Context) {
@@ -167,24 +210,54 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				return
 			}
 
+			r.serveFeed(c, func(ctx context.Context, rng feedRange) ([]feed.Candidate, error) {
+				// Serve the range from the Redis timeline when possible and fall back to the follows join otherwise
+				count := 2 * rng.Limit
+				postIDs, complete, err := r.timeline.PostIDs(ctx, userID.(int), &rng.Until, count)
+				if err != nil {
+					utils.LogError(c, err)
+				} else if complete {
+					candidates, err := r.loadFeedCandidates(ctx, userID.(int), `
+				WHERE p.id = ANY($8)`, rng, postIDs)
+					// A short range is final only when the timeline has no more posts, otherwise
+					// too many of the IDs read were filtered out and the join finds the rest
+					if err != nil || len(candidates) >= rng.Limit || len(postIDs) < count {
+						return candidates, err
+					}
+				}
+				return r.loadFeedCandidates(ctx, userID.(int), `
+				JOIN follows f ON f.profile_id = p.creator_id
+				WHERE f.follower_id = $1`, rng)
+			})
+		})
+
+		postRouter.GET("/mentions", func(c *gin.Context) {
+			page, err := utils.ParsePagination(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursorTimestamp, cursorID := page.CursorArgs()
+
 			rows, err := r.pgClient.Query(c.Request.Context(), `
 				SELECT p.id, u.username, p.image_url, p.description, p.creation_timestamp, up.name, up.surname, up.profile_image_url,
 				   (SELECT COUNT(*) FROM posts_likes l WHERE l.post_id = p.id) AS likes_count,
-				   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
+				   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND NOT c.is_deleted) AS comments_count,
 				   EXISTS (SELECT 1 FROM posts_likes l WHERE l.post_id = p.id AND l.user_id = $1) AS user_liked
 				FROM posts p
 				JOIN users u ON p.creator_id = u.id
 				JOIN user_profiles up ON up.user_id = u.id
-				JOIN follows f ON f.profile_id = p.creator_id
-				WHERE f.follower_id = $1
-				ORDER BY p.creation_timestamp DESC`, userID)
+				WHERE EXISTS (SELECT 1 FROM mentions m WHERE m.post_id = p.id AND m.user_id = $1)
+				  AND `+visibleToSQL("p.creator_id", "$1")+`
+				  AND ($2 IS NULL OR (p.creation_timestamp, p.id) < ($2, $3))
+				ORDER BY p.creation_timestamp DESC, p.id DESC
+				LIMIT $4`, c.GetInt("user_id"), cursorTimestamp, cursorID, page.FetchLimit())
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
 			defer rows.Close()
-
 			posts := []models.Post{}
 			for rows.Next() {
 				var post models.Post
@@ -196,19 +269,87 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				}
 				posts = append(posts, post)
 			}
-
-			c.JSON(http.StatusOK, posts)
+			if err := rows.Err(); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			resp := utils.Paginate(posts, page, models.Post.CursorKey)
+			if err := r.attachPostDetails(c.Request.Context(), resp.Items); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, resp)
 		})
 
 		postRouter.DELETE("/:post_id", r.middleware.RequirePostOwnership("post_id"), func(c *gin.Context) {
-			postID := c.Param("post_id")
+			postID, err := strconv.Atoi(c.Param("post_id"))
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post id"})
+				return
+			}
+
+			tx, err := r.pgClient.Begin(c.Request.Context())
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer tx.Rollback(c.Request.Context())
 
-			_, err := r.pgClient.Exec(c.Request.Context(), "DELETE FROM posts WHERE id = $1", postID)
+			_, err = tx.Exec(c.Request.Context(), "DELETE FROM post_tags WHERE post_id = $1", postID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			_, err = tx.Exec(c.Request.Context(), `
+				DELETE FROM mentions
+				WHERE post_id = $1 OR comment_id IN (SELECT id FROM comments WHERE post_id = $1)`, postID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if err := notifications.DeleteForPost(c.Request.Context(), tx, postID); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			images, err := deletePostMedia(c.Request.Context(), tx, postID)
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
+			var coverURL string
+			err = tx.QueryRow(c.Request.Context(), "DELETE FROM posts WHERE id = $1 RETURNING image_url", postID).Scan(&coverURL)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			// Posts from before carousels have no media rows, their single image is the cover
+			if len(images) == 0 {
+				images = append(images, utils.UploadedImage{URL: coverURL})
+			}
+
+			if err := tx.Commit(c.Request.Context()); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			// The files are removed once the rows are gone, a failure only leaves unreferenced files behind
+			if err := utils.RemovePostImages(c.Request.Context(), r.media, images); err != nil {
+				utils.LogError(c, err)
+			}
+
+			// RequirePostOwnership makes the user the author
+			if err := r.timeline.RemovePost(c.Request.Context(), c.GetInt("user_id"), postID); err != nil {
+				utils.LogError(c, err)
+			}
 
 			c.JSON(http.StatusOK, gin.H{})
 		})
@@ -222,25 +363,69 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				return
 			}
 
-			_, err := r.pgClient.Exec(c.Request.Context(),
+			postIDInt, err := strconv.Atoi(postID)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post ID"})
+				return
+			}
+
+			tx, err := r.pgClient.Begin(c.Request.Context())
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer tx.Rollback(c.Request.Context())
+
+			_, err = tx.Exec(c.Request.Context(),
 				"UPDATE posts SET description = $1 WHERE id = $2",
-				req.Description, postID)
+				req.Description, postIDInt)
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 				return
 			}
 
+			if err := syncPostTags(c.Request.Context(), tx, postIDInt, req.Description); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			mentioned, err := syncMentions(c.Request.Context(), tx, mentionsOfPost, postIDInt, req.Description)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			if err := tx.Commit(c.Request.Context()); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			r.notifyMentions(c, c.GetInt("user_id"), postIDInt, nil, mentioned)
+
 			c.JSON(http.StatusOK, gin.H{})
 		})
 
-		postRouter.POST("/:post_id/like", func(c *gin.Context) {
+		postRouter.POST("/:post_id/like", likeLimit, func(c *gin.Context) {
 			postID, err := strconv.Atoi(c.Param("post_id"))
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post id"})
 				return
 			}
 
+			visible, err := r.canViewPost(c.Request.Context(), c.GetInt("user_id"), postID)
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			if !visible {
+				c.JSON(http.StatusNotFound, gin.H{"error": "post not found"})
+				return
+			}
+
 			likerID, exists := c.Get("user_id")
 			if !exists {
 				c.JSON(http.StatusUnauthorized, gin.H{"error": "user not authenticated"})
@@ -260,10 +445,17 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				return
 			}
 
+			if authorID, err := r.postAuthorID(c.Request.Context(), postID); err != nil {
+				utils.LogError(c, err)
+			} else {
+				r.notify(c, notifications.Event{Type: notifications.TypePostLike, RecipientID: authorID, ActorID: likerID.(int), PostID: &postID})
+			}
+			r.publishLikes(c, postID)
+
 			c.JSON(http.StatusOK, gin.H{})
 		})
 
-		postRouter.DELETE("/:post_id/like", func(c *gin.Context) {
+		postRouter.DELETE("/:post_id/like", likeLimit, func(c *gin.Context) {
 			postID, err := strconv.Atoi(c.Param("post_id"))
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid post id"})
@@ -285,7 +477,145 @@ func (r *RoutesManager) RegisterPostsRoutes(router *gin.Engine) {
 				return
 			}
 
+			if authorID, err := r.postAuthorID(c.Request.Context(), postID); err == nil {
+				r.retractNotification(c, notifications.Event{Type: notifications.TypePostLike, RecipientID: authorID, ActorID: unlikerID.(int), PostID: &postID})
+			} else if err != pgx.ErrNoRows {
+				utils.LogError(c, err)
+			}
+			r.publishLikes(c, postID)
+
 			c.JSON(http.StatusOK, gin.H{})
 		})
 	}
 }
+
+// insertPost stores the post and its ordered media in a single transaction, the first image is the cover.
+// It also returns the users mentioned in the description.
+func (r *RoutesManager) insertPost(ctx context.Context, creatorID int, req models.AddPostRequest, images []utils.UploadedImage) (int, time.Time, []int, error) {
+	tx, err := r.pgClient.Begin(ctx)
+	if err != nil {
+		return 0, time.Time{}, nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var postID int
+	var createdAt time.Time
+	err = tx.QueryRow(ctx,
+		"INSERT INTO posts (image_url, description, creator_id) VALUES ($1, $2, $3) RETURNING id, creation_timestamp",
+		images[0].URL, req.Description, creatorID).Scan(&postID, &createdAt)
+	if err != nil {
+		return 0, time.Time{}, nil, err
+	}
+
+	for i, image := range images {
+		altText := ""
+		if i < len(req.AltTexts) {
+			altText = req.AltTexts[i]
+		}
+		_, err = tx.Exec(ctx,
+			"INSERT INTO post_media (post_id, position, url, width, height, alt_text) VALUES ($1, $2, $3, $4, $5, $6)",
+			postID, i, image.URL, image.Width, image.Height, altText)
+		if err != nil {
+			return 0, time.Time{}, nil, err
+		}
+	}
+
+	if err := syncPostTags(ctx, tx, postID, req.Description); err != nil {
+		return 0, time.Time{}, nil, err
+	}
+	mentioned, err := syncMentions(ctx, tx, mentionsOfPost, postID, req.Description)
+	if err != nil {
+		return 0, time.Time{}, nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, time.Time{}, nil, err
+	}
+	return postID, createdAt, mentioned, nil
+}
+
+// deletePostMedia deletes the media rows of a post and returns their images
+func deletePostMedia(ctx context.Context, tx pgx.Tx, postID int) ([]utils.UploadedImage, error) {
+	rows, err := tx.Query(ctx, "DELETE FROM post_media WHERE post_id = $1 RETURNING url", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := []utils.UploadedImage{}
+	for rows.Next() {
+		var image utils.UploadedImage
+		if err := rows.Scan(&image.URL); err != nil {
+			return nil, err
+		}
+		images = append(images, image)
+	}
+	return images, rows.Err()
+}
+
+// syncPostTags replaces the hashtags of a post with the ones in its description
+func syncPostTags(ctx context.Context, tx pgx.Tx, postID int, description string) error {
+	_, err := tx.Exec(ctx, "DELETE FROM post_tags WHERE post_id = $1", postID)
+	if err != nil {
+		return err
+	}
+
+	tags := entities.ExtractHashtags(description)
+	if len(tags) == 0 {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx, "INSERT INTO tags (name) SELECT UNNEST($1::text[]) ON CONFLICT (name) DO NOTHING", tags)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(ctx, `
+		INSERT INTO post_tags (post_id, tag_id)
+		SELECT $1, id FROM tags WHERE name = ANY($2)`, postID, tags)
+	return err
+}
+
+// attachPostMedia loads the carousel items of the given posts, posts without media rows get their single image
+func (r *RoutesManager) attachPostMedia(ctx context.Context, posts []models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	postIDs := make([]int, len(posts))
+	for i, post := range posts {
+		postIDs[i] = post.ID
+	}
+
+	rows, err := r.pgClient.Query(ctx, `
+		SELECT post_id, url, width, height, alt_text
+		FROM post_media
+		WHERE post_id = ANY($1)
+		ORDER BY post_id, position`, postIDs)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	media := map[int][]models.PostMedia{}
+	for rows.Next() {
+		var postID int
+		var item models.PostMedia
+		if err := rows.Scan(&postID, &item.URL, &item.Width, &item.Height, &item.AltText); err != nil {
+			return err
+		}
+		item.Renditions = utils.ImageRenditions(item.URL)
+		media[postID] = append(media[postID], item)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range posts {
+		posts[i].ImageRenditions = utils.ImageRenditions(posts[i].ImageURL)
+		posts[i].Media = media[posts[i].ID]
+		if posts[i].Media == nil {
+			posts[i].Media = []models.PostMedia{{URL: posts[i].ImageURL, Renditions: posts[i].ImageRenditions}}
+		}
+	}
+	return nil
+}
@@ -1,9 +1,15 @@
 package routes
 
 import (
+	"context"
+	"instagramplusbackend/internal/middleware"
 	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/notifications"
+	"instagramplusbackend/internal/storage"
 	"instagramplusbackend/internal/utils"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5"
@@ -12,6 +18,8 @@ import (
 func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 	profileRouter := router.Group("/profile")
 	profileRouter.Use(r.middleware.RequireAuth())
+
+	followLimit := r.middleware.RateLimit(middleware.RateLimitPolicy{Name: "profile:follow", Limit: 60, Period: time.Hour, PremiumLimit: 120})
 	{
 		userIdProfileRouter := profileRouter.Group("/:user_id")
 		{
@@ -24,25 +32,60 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
 					return
 				}
-				if req.Name == "" && req.Surname == "" && req.Description == "" && req.Gender == "" {
+				if req.Name == "" && req.Surname == "" && req.Description == "" && req.Gender == "" && req.IsPrivate == nil {
 					c.JSON(http.StatusBadRequest, gin.H{"error": "at least one field is required"})
 					return
 				}
 
-				_, err := r.pgClient.Exec(c.Request.Context(), `
+				ownerID, _ := strconv.Atoi(userID)
+				tx, err := r.pgClient.Begin(c.Request.Context())
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				defer tx.Rollback(c.Request.Context())
+
+				_, err = tx.Exec(c.Request.Context(), `
 					UPDATE user_profiles 
 					SET name = COALESCE(NULLIF($1, ''), name), 
 						surname = COALESCE(NULLIF($2, ''), surname),
 						description = COALESCE(NULLIF($3, ''), description),
-						gender = COALESCE(NULLIF($4, '')::gender, gender)
+						gender = COALESCE(NULLIF($4, '')::gender, gender),
+						is_private = COALESCE($6, is_private)
 					WHERE user_id = $5`,
-					req.Name, req.Surname, req.Description, req.Gender, userID)
+					req.Name, req.Surname, req.Description, req.Gender, ownerID, req.IsPrivate)
 				if err != nil {
 					utils.LogError(c, err)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
 					return
 				}
 
+				// Pending follow requests are approved when the account becomes public
+				approvedIDs := []int{}
+				if req.IsPrivate != nil && !*req.IsPrivate {
+					approvedIDs, err = approveAllFollowRequests(c.Request.Context(), tx, ownerID)
+					if err != nil {
+						utils.LogError(c, err)
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+						return
+					}
+				}
+
+				if err := tx.Commit(c.Request.Context()); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				for _, requesterID := range approvedIDs {
+					if err := r.timeline.Follow(c.Request.Context(), requesterID, ownerID); err != nil {
+						utils.LogError(c, err)
+					}
+					r.retractNotification(c, notifications.Event{Type: notifications.TypeFollowRequest, RecipientID: ownerID, ActorID: requesterID})
+					r.notify(c, notifications.Event{Type: notifications.TypeFollowAccepted, RecipientID: requesterID, ActorID: ownerID})
+				}
+
 				c.JSON(http.StatusOK, gin.H{})
 			})
 
@@ -63,14 +106,14 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 				}
 
 				if oldImagePath != "" {
-					if err = utils.RemoveProfileImage(oldImagePath); err != nil {
+					if err = utils.RemoveProfileImage(c.Request.Context(), r.media, oldImagePath); err != nil {
 						utils.LogError(c, err)
 						//c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove old image"})
 						//return
 					}
 				}
 
-				imageURL, err := utils.UploadProfileImage(c)
+				imageURL, err := utils.UploadProfileImage(c, r.media)
 				if err != nil {
 					utils.LogError(c, err)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to upload image"})
@@ -106,7 +149,7 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 				}
 
 				if oldImagePath != "" {
-					if err = utils.RemoveProfileImage(oldImagePath); err != nil {
+					if err = utils.RemoveProfileImage(c.Request.Context(), r.media, oldImagePath); err != nil && err != storage.ErrNotFound {
 						utils.LogError(c, err)
 						c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to remove old image"})
 						return
@@ -135,11 +178,11 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 
 				var user models.Profile
 				err := r.pgClient.QueryRow(c.Request.Context(), `
-					SELECT u.username, p.name, p.surname, p.description, p.profile_image_url, p.gender, p.birth, u.creation_timestamp
+					SELECT u.username, p.name, p.surname, p.description, p.profile_image_url, p.gender, p.birth, u.creation_timestamp, p.is_private
 					FROM users u
 					JOIN user_profiles p ON u.id = p.user_id
 					WHERE u.id = $1`, userID).Scan(
-					&user.Username, &user.Name, &user.Surname, &user.Description, &user.ProfileImageURL, &user.Gender, &user.BirthDate, &user.CreationTimestamp)
+					&user.Username, &user.Name, &user.Surname, &user.Description, &user.ProfileImageURL, &user.Gender, &user.BirthDate, &user.CreationTimestamp, &user.IsPrivate)
 				if err != nil {
 					if err == pgx.ErrNoRows {
 						c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
@@ -183,6 +226,7 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 					}
 				}
 				user.AlreadyFollowed = alreadyFollowed
+				user.ProfileImageRenditions = utils.ImageRenditions(user.ProfileImageURL)
 
 				c.JSON(http.StatusOK, user)
 			})
@@ -200,11 +244,11 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 				var userID int
 				var user models.Profile
 				err := r.pgClient.QueryRow(c.Request.Context(), `
-					SELECT u.id, u.username, p.name, p.surname, p.description, p.profile_image_url, p.gender, p.birth, u.creation_timestamp
+					SELECT u.id, u.username, p.name, p.surname, p.description, p.profile_image_url, p.gender, p.birth, u.creation_timestamp, p.is_private
 					FROM users u
 					JOIN user_profiles p ON u.id = p.user_id
 					WHERE u.username = $1`, username).Scan(
-					&userID, &user.Username, &user.Name, &user.Surname, &user.Description, &user.ProfileImageURL, &user.Gender, &user.BirthDate, &user.CreationTimestamp)
+					&userID, &user.Username, &user.Name, &user.Surname, &user.Description, &user.ProfileImageURL, &user.Gender, &user.BirthDate, &user.CreationTimestamp, &user.IsPrivate)
 				if err != nil {
 					if err == pgx.ErrNoRows {
 						c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
@@ -215,6 +259,18 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 					return
 				}
 
+				// Blocked profiles look like they do not exist
+				blocked, err := r.isBlocked(c.Request.Context(), c.GetInt("user_id"), userID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				if blocked {
+					c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+					return
+				}
+
 				// Get followers and following count
 				var followersCount, followingCount int
 				err = r.pgClient.QueryRow(c.Request.Context(), `
@@ -239,10 +295,12 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 				// Get whether the current user already follows this profile
 				currentUserID, exists := c.Get("user_id")
 				alreadyFollowed := false
+				followRequested := false
 				if exists {
 					err = r.pgClient.QueryRow(c.Request.Context(), `
-						SELECT EXISTS(SELECT 1 FROM follows WHERE profile_id = $1 AND follower_id = $2)
-					`, userID, currentUserID).Scan(&alreadyFollowed)
+						SELECT EXISTS(SELECT 1 FROM follows WHERE profile_id = $1 AND follower_id = $2),
+							EXISTS(SELECT 1 FROM follow_requests WHERE profile_id = $1 AND requester_id = $2)
+					`, userID, currentUserID).Scan(&alreadyFollowed, &followRequested)
 					if err != nil {
 						utils.LogError(c, err)
 						c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
@@ -251,11 +309,13 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 				}
 
 				user.AlreadyFollowed = alreadyFollowed
+				user.FollowRequested = followRequested
+				user.ProfileImageRenditions = utils.ImageRenditions(user.ProfileImageURL)
 
 				c.JSON(http.StatusOK, user)
 			})
 
-			usernameProfileRouter.POST("/follow", func(c *gin.Context) {
+			usernameProfileRouter.POST("/follow", followLimit, func(c *gin.Context) {
 				toFollowNick := c.Param("username")
 				if toFollowNick == "" {
 					c.JSON(http.StatusBadRequest, gin.H{"error": "username is required"})
@@ -291,6 +351,52 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 					return
 				}
 
+				blocked, err := r.isBlocked(c.Request.Context(), userThatFollowsID.(int), toFollowID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				if blocked {
+					c.JSON(http.StatusForbidden, gin.H{"error": "you cannot follow this user"})
+					return
+				}
+
+				var isPrivate, alreadyFollowing bool
+				err = r.pgClient.QueryRow(c.Request.Context(), `
+					SELECT p.is_private, EXISTS(SELECT 1 FROM follows WHERE profile_id = $1 AND follower_id = $2)
+					FROM user_profiles p
+					WHERE p.user_id = $1`, toFollowID, userThatFollowsID).Scan(&isPrivate, &alreadyFollowing)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				if alreadyFollowing {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "you are already following this user"})
+					return
+				}
+
+				// Following a private account only creates a request the owner has to approve
+				if isPrivate {
+					_, err = r.pgClient.Exec(c.Request.Context(), `
+						INSERT INTO follow_requests (profile_id, requester_id)
+						VALUES ($1, $2)`, toFollowID, userThatFollowsID)
+					if err != nil {
+						if utils.IsDuplicatePgxError(err) {
+							c.JSON(http.StatusBadRequest, gin.H{"error": "you already requested to follow this user"})
+							return
+						}
+						utils.LogError(c, err)
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+						return
+					}
+
+					r.notify(c, notifications.Event{Type: notifications.TypeFollowRequest, RecipientID: toFollowID, ActorID: userThatFollowsID.(int)})
+					c.JSON(http.StatusOK, gin.H{"status": "requested"})
+					return
+				}
+
 				_, err = r.pgClient.Exec(c.Request.Context(), `
 					INSERT INTO follows (profile_id, follower_id) 
 					VALUES ($1, $2)`, toFollowID, userThatFollowsID)
@@ -304,7 +410,12 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 					return
 				}
 
-				c.JSON(http.StatusOK, gin.H{})
+				if err := r.timeline.Follow(c.Request.Context(), userThatFollowsID.(int), toFollowID); err != nil {
+					utils.LogError(c, err)
+				}
+				r.notify(c, notifications.Event{Type: notifications.TypeFollow, RecipientID: toFollowID, ActorID: userThatFollowsID.(int)})
+
+				c.JSON(http.StatusOK, gin.H{"status": "following"})
 			})
 
 			usernameProfileRouter.DELETE("/follow", func(c *gin.Context) {
@@ -352,6 +463,162 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 					return
 				}
 
+				// Unfollowing also withdraws a pending follow request
+				_, err = r.pgClient.Exec(c.Request.Context(), `
+					DELETE FROM follow_requests
+					WHERE profile_id = $1 AND requester_id = $2`, toUnfollowID, userThatUnfollowsID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				if err := r.timeline.Unfollow(c.Request.Context(), userThatUnfollowsID.(int), toUnfollowID); err != nil {
+					utils.LogError(c, err)
+				}
+				r.retractNotification(c, notifications.Event{Type: notifications.TypeFollow, RecipientID: toUnfollowID, ActorID: userThatUnfollowsID.(int)})
+				r.retractNotification(c, notifications.Event{Type: notifications.TypeFollowRequest, RecipientID: toUnfollowID, ActorID: userThatUnfollowsID.(int)})
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+
+			usernameProfileRouter.POST("/block", func(c *gin.Context) {
+				blockerID := c.GetInt("user_id")
+
+				var blockedID int
+				err := r.pgClient.QueryRow(c.Request.Context(), `SELECT id FROM users WHERE username = $1`, c.Param("username")).Scan(&blockedID)
+				if err != nil {
+					if err == pgx.ErrNoRows {
+						c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				if blockedID == blockerID {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot block yourself"})
+					return
+				}
+
+				tx, err := r.pgClient.Begin(c.Request.Context())
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				defer tx.Rollback(c.Request.Context())
+
+				_, err = tx.Exec(c.Request.Context(), `
+					INSERT INTO blocks (blocker_id, blocked_id)
+					VALUES ($1, $2)`, blockerID, blockedID)
+				if err != nil {
+					if utils.IsDuplicatePgxError(err) {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "you already blocked this user"})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				// Blocking removes follows and pending follow requests in both directions
+				_, err = tx.Exec(c.Request.Context(), `
+					DELETE FROM follows
+					WHERE (profile_id = $1 AND follower_id = $2) OR (profile_id = $2 AND follower_id = $1)`, blockerID, blockedID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				_, err = tx.Exec(c.Request.Context(), `
+					DELETE FROM follow_requests
+					WHERE (profile_id = $1 AND requester_id = $2) OR (profile_id = $2 AND requester_id = $1)`, blockerID, blockedID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				if err := tx.Commit(c.Request.Context()); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				if err := r.timeline.Unfollow(c.Request.Context(), blockerID, blockedID); err != nil {
+					utils.LogError(c, err)
+				}
+				if err := r.timeline.Unfollow(c.Request.Context(), blockedID, blockerID); err != nil {
+					utils.LogError(c, err)
+				}
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+
+			usernameProfileRouter.DELETE("/block", func(c *gin.Context) {
+				_, err := r.pgClient.Exec(c.Request.Context(), `
+					DELETE FROM blocks
+					WHERE blocker_id = $1 AND blocked_id = (SELECT id FROM users WHERE username = $2)`,
+					c.GetInt("user_id"), c.Param("username"))
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+
+			usernameProfileRouter.POST("/mute", func(c *gin.Context) {
+				muterID := c.GetInt("user_id")
+
+				var mutedID int
+				err := r.pgClient.QueryRow(c.Request.Context(), `SELECT id FROM users WHERE username = $1`, c.Param("username")).Scan(&mutedID)
+				if err != nil {
+					if err == pgx.ErrNoRows {
+						c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				if mutedID == muterID {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "you cannot mute yourself"})
+					return
+				}
+
+				// Muting is silent, follows stay in place and the muted user is not notified
+				_, err = r.pgClient.Exec(c.Request.Context(), `
+					INSERT INTO mutes (muter_id, muted_id)
+					VALUES ($1, $2)`, muterID, mutedID)
+				if err != nil {
+					if utils.IsDuplicatePgxError(err) {
+						c.JSON(http.StatusBadRequest, gin.H{"error": "you already muted this user"})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+
+			usernameProfileRouter.DELETE("/mute", func(c *gin.Context) {
+				_, err := r.pgClient.Exec(c.Request.Context(), `
+					DELETE FROM mutes
+					WHERE muter_id = $1 AND muted_id = (SELECT id FROM users WHERE username = $2)`,
+					c.GetInt("user_id"), c.Param("username"))
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
 				c.JSON(http.StatusOK, gin.H{})
 			})
 
@@ -374,12 +641,33 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 					return
 				}
 
+				visible, err := r.canViewUser(c.Request.Context(), c.GetInt("user_id"), userID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				if !visible {
+					c.JSON(http.StatusForbidden, gin.H{"error": "this account is private"})
+					return
+				}
+
+				page, err := utils.ParsePagination(c)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				cursorTimestamp, cursorID := page.CursorArgs()
+
 				rows, err := r.pgClient.Query(c.Request.Context(), `
-					SELECT u.username, p.name, p.surname, p.profile_image_url
+					SELECT u.id, u.username, p.name, p.surname, p.profile_image_url, f.creation_timestamp
 					FROM follows f
 					JOIN users u ON f.follower_id = u.id
 					JOIN user_profiles p ON u.id = p.user_id
-					WHERE f.profile_id = $1`, userID)
+					WHERE f.profile_id = $1 AND `+notBlockedSQL("u.id", "$5")+`
+					  AND ($2 IS NULL OR (f.creation_timestamp, u.id) < ($2, $3))
+					ORDER BY f.creation_timestamp DESC, u.id DESC
+					LIMIT $4`, userID, cursorTimestamp, cursorID, page.FetchLimit(), c.GetInt("user_id"))
 				if err != nil {
 					utils.LogError(c, err)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
@@ -387,21 +675,22 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 				}
 				defer rows.Close()
 
-				followers := []gin.H{}
+				followers := []models.ProfileSummary{}
 				for rows.Next() {
-					var username, name, surname, profileImageURL string
-					if err := rows.Scan(&username, &name, &surname, &profileImageURL); err != nil {
+					var profile models.ProfileSummary
+					if err := rows.Scan(&profile.ID, &profile.Username, &profile.Name, &profile.Surname, &profile.ProfileImageURL, &profile.Since); err != nil {
 						utils.LogError(c, err)
-						continue
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+						return
 					}
-					followers = append(followers, gin.H{
-						"username":          username,
-						"name":              name,
-						"surname":           surname,
-						"profile_image_url": profileImageURL,
-					})
-				}
-				c.JSON(http.StatusOK, followers)
+					followers = append(followers, profile)
+				}
+				if err := rows.Err(); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				c.JSON(http.StatusOK, utils.Paginate(followers, page, models.ProfileSummary.CursorKey))
 			})
 
 			usernameProfileRouter.GET("/following", func(c *gin.Context) {
@@ -423,12 +712,33 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 					return
 				}
 
+				visible, err := r.canViewUser(c.Request.Context(), c.GetInt("user_id"), userID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				if !visible {
+					c.JSON(http.StatusForbidden, gin.H{"error": "this account is private"})
+					return
+				}
+
+				page, err := utils.ParsePagination(c)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				cursorTimestamp, cursorID := page.CursorArgs()
+
 				rows, err := r.pgClient.Query(c.Request.Context(), `
-					SELECT u.username, p.name, p.surname, p.profile_image_url
+					SELECT u.id, u.username, p.name, p.surname, p.profile_image_url, f.creation_timestamp
 					FROM follows f
 					JOIN users u ON f.profile_id = u.id
 					JOIN user_profiles p ON u.id = p.user_id
-					WHERE f.follower_id = $1`, userID)
+					WHERE f.follower_id = $1 AND `+notBlockedSQL("u.id", "$5")+`
+					  AND ($2 IS NULL OR (f.creation_timestamp, u.id) < ($2, $3))
+					ORDER BY f.creation_timestamp DESC, u.id DESC
+					LIMIT $4`, userID, cursorTimestamp, cursorID, page.FetchLimit(), c.GetInt("user_id"))
 				if err != nil {
 					utils.LogError(c, err)
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
@@ -436,22 +746,252 @@ func (r *RoutesManager) RegisterUserRoutes(router *gin.Engine) {
 				}
 				defer rows.Close()
 
-				following := []gin.H{}
+				following := []models.ProfileSummary{}
 				for rows.Next() {
-					var username, name, surname, profileImageURL string
-					if err := rows.Scan(&username, &name, &surname, &profileImageURL); err != nil {
+					var profile models.ProfileSummary
+					if err := rows.Scan(&profile.ID, &profile.Username, &profile.Name, &profile.Surname, &profile.ProfileImageURL, &profile.Since); err != nil {
 						utils.LogError(c, err)
-						continue
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+						return
 					}
-					following = append(following, gin.H{
-						"username":          username,
-						"name":              name,
-						"surname":           surname,
-						"profile_image_url": profileImageURL,
-					})
-				}
-				c.JSON(http.StatusOK, following)
+					following = append(following, profile)
+				}
+				if err := rows.Err(); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				c.JSON(http.StatusOK, utils.Paginate(following, page, models.ProfileSummary.CursorKey))
 			})
 		}
+
+		profileRouter.GET("/blocked", func(c *gin.Context) {
+			page, err := utils.ParsePagination(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursorTimestamp, cursorID := page.CursorArgs()
+
+			rows, err := r.pgClient.Query(c.Request.Context(), `
+				SELECT u.id, u.username, p.name, p.surname, p.profile_image_url, b.creation_timestamp
+				FROM blocks b
+				JOIN users u ON b.blocked_id = u.id
+				JOIN user_profiles p ON u.id = p.user_id
+				WHERE b.blocker_id = $1
+				  AND ($2 IS NULL OR (b.creation_timestamp, u.id) < ($2, $3))
+				ORDER BY b.creation_timestamp DESC, u.id DESC
+				LIMIT $4`, c.GetInt("user_id"), cursorTimestamp, cursorID, page.FetchLimit())
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer rows.Close()
+
+			blocked := []models.ProfileSummary{}
+			for rows.Next() {
+				var profile models.ProfileSummary
+				if err := rows.Scan(&profile.ID, &profile.Username, &profile.Name, &profile.Surname, &profile.ProfileImageURL, &profile.Since); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				blocked = append(blocked, profile)
+			}
+			if err := rows.Err(); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, utils.Paginate(blocked, page, models.ProfileSummary.CursorKey))
+		})
+
+		profileRouter.GET("/muted", func(c *gin.Context) {
+			page, err := utils.ParsePagination(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursorTimestamp, cursorID := page.CursorArgs()
+
+			rows, err := r.pgClient.Query(c.Request.Context(), `
+				SELECT u.id, u.username, p.name, p.surname, p.profile_image_url, m.creation_timestamp
+				FROM mutes m
+				JOIN users u ON m.muted_id = u.id
+				JOIN user_profiles p ON u.id = p.user_id
+				WHERE m.muter_id = $1
+				  AND ($2 IS NULL OR (m.creation_timestamp, u.id) < ($2, $3))
+				ORDER BY m.creation_timestamp DESC, u.id DESC
+				LIMIT $4`, c.GetInt("user_id"), cursorTimestamp, cursorID, page.FetchLimit())
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer rows.Close()
+
+			muted := []models.ProfileSummary{}
+			for rows.Next() {
+				var profile models.ProfileSummary
+				if err := rows.Scan(&profile.ID, &profile.Username, &profile.Name, &profile.Surname, &profile.ProfileImageURL, &profile.Since); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				muted = append(muted, profile)
+			}
+			if err := rows.Err(); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, utils.Paginate(muted, page, models.ProfileSummary.CursorKey))
+		})
+
+		requestsRouter := profileRouter.Group("/requests")
+		{
+			requestsRouter.GET("", func(c *gin.Context) {
+				page, err := utils.ParsePagination(c)
+				if err != nil {
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				cursorTimestamp, cursorID := page.CursorArgs()
+
+				rows, err := r.pgClient.Query(c.Request.Context(), `
+					SELECT u.id, u.username, p.name, p.surname, p.profile_image_url, fr.creation_timestamp
+					FROM follow_requests fr
+					JOIN users u ON fr.requester_id = u.id
+					JOIN user_profiles p ON u.id = p.user_id
+					WHERE fr.profile_id = $1
+					  AND ($2 IS NULL OR (fr.creation_timestamp, u.id) < ($2, $3))
+					ORDER BY fr.creation_timestamp DESC, u.id DESC
+					LIMIT $4`, c.GetInt("user_id"), cursorTimestamp, cursorID, page.FetchLimit())
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				defer rows.Close()
+
+				requesters := []models.ProfileSummary{}
+				for rows.Next() {
+					var profile models.ProfileSummary
+					if err := rows.Scan(&profile.ID, &profile.Username, &profile.Name, &profile.Surname, &profile.ProfileImageURL, &profile.Since); err != nil {
+						utils.LogError(c, err)
+						c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+						return
+					}
+					requesters = append(requesters, profile)
+				}
+				if err := rows.Err(); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				c.JSON(http.StatusOK, utils.Paginate(requesters, page, models.ProfileSummary.CursorKey))
+			})
+
+			requestsRouter.POST("/:username/approve", func(c *gin.Context) {
+				ownerID := c.GetInt("user_id")
+
+				tx, err := r.pgClient.Begin(c.Request.Context())
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				defer tx.Rollback(c.Request.Context())
+
+				var requesterID int
+				err = tx.QueryRow(c.Request.Context(), `
+					DELETE FROM follow_requests
+					WHERE profile_id = $1 AND requester_id = (SELECT id FROM users WHERE username = $2)
+					RETURNING requester_id`, ownerID, c.Param("username")).Scan(&requesterID)
+				if err != nil {
+					if err == pgx.ErrNoRows {
+						c.JSON(http.StatusNotFound, gin.H{"error": "follow request not found"})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				_, err = tx.Exec(c.Request.Context(), `
+					INSERT INTO follows (profile_id, follower_id)
+					VALUES ($1, $2)
+					ON CONFLICT DO NOTHING`, ownerID, requesterID)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				if err := tx.Commit(c.Request.Context()); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				if err := r.timeline.Follow(c.Request.Context(), requesterID, ownerID); err != nil {
+					utils.LogError(c, err)
+				}
+				r.retractNotification(c, notifications.Event{Type: notifications.TypeFollowRequest, RecipientID: ownerID, ActorID: requesterID})
+				r.notify(c, notifications.Event{Type: notifications.TypeFollowAccepted, RecipientID: requesterID, ActorID: ownerID})
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+
+			requestsRouter.POST("/:username/reject", func(c *gin.Context) {
+				var requesterID int
+				err := r.pgClient.QueryRow(c.Request.Context(), `
+					DELETE FROM follow_requests
+					WHERE profile_id = $1 AND requester_id = (SELECT id FROM users WHERE username = $2)
+					RETURNING requester_id`,
+					c.GetInt("user_id"), c.Param("username")).Scan(&requesterID)
+				if err != nil {
+					if err == pgx.ErrNoRows {
+						c.JSON(http.StatusNotFound, gin.H{"error": "follow request not found"})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				r.retractNotification(c, notifications.Event{Type: notifications.TypeFollowRequest, RecipientID: c.GetInt("user_id"), ActorID: requesterID})
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+		}
+	}
+}
+
+// approveAllFollowRequests turns every pending request for the profile into a follow and returns the requesters
+func approveAllFollowRequests(ctx context.Context, tx pgx.Tx, profileID int) ([]int, error) {
+	rows, err := tx.Query(ctx, `
+		WITH approved AS (
+			DELETE FROM follow_requests WHERE profile_id = $1 RETURNING requester_id
+		), followed AS (
+			INSERT INTO follows (profile_id, follower_id)
+			SELECT $1, requester_id FROM approved
+			ON CONFLICT DO NOTHING
+		)
+		SELECT requester_id FROM approved`, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	requesterIDs := []int{}
+	for rows.Next() {
+		var requesterID int
+		if err := rows.Scan(&requesterID); err != nil {
+			return nil, err
+		}
+		requesterIDs = append(requesterIDs, requesterID)
 	}
+	return requesterIDs, rows.Err()
 }
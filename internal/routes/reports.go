@@ -1,10 +1,13 @@
 package routes
 
 import (
+	"instagramplusbackend/auth"
+	"instagramplusbackend/internal/middleware"
 	"instagramplusbackend/internal/models"
 	"instagramplusbackend/internal/utils"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,8 +15,10 @@ import (
 func (r *RoutesManager) RegisterReportsRoutes(router *gin.Engine) {
 	reportsRouter := router.Group("/reports")
 	reportsRouter.Use(r.middleware.RequireAuth())
+
+	reportLimit := r.middleware.RateLimit(middleware.RateLimitPolicy{Name: "reports:create", Limit: 10, Period: time.Hour})
 	{
-		reportsRouter.POST("/user/:username", func(c *gin.Context) {
+		reportsRouter.POST("/user/:username", reportLimit, func(c *gin.Context) {
 			var req models.ReportedRequest
 			if err := c.ShouldBindJSON(&req); err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
@@ -41,7 +46,7 @@ func (r *RoutesManager) RegisterReportsRoutes(router *gin.Engine) {
 			c.JSON(http.StatusOK, gin.H{})
 		})
 
-		reportsRouter.POST("/post/:post_id", func(c *gin.Context) {
+		reportsRouter.POST("/post/:post_id", reportLimit, func(c *gin.Context) {
 			var req models.ReportedRequest
 			if err := c.ShouldBindJSON(&req); err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
@@ -72,7 +77,7 @@ func (r *RoutesManager) RegisterReportsRoutes(router *gin.Engine) {
 			c.JSON(http.StatusOK, gin.H{})
 		})
 
-		reportsRouter.POST("/comment/:comment_id", func(c *gin.Context) {
+		reportsRouter.POST("/comment/:comment_id", reportLimit, func(c *gin.Context) {
 			var req models.ReportedRequest
 			if err := c.ShouldBindJSON(&req); err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
@@ -104,7 +109,7 @@ func (r *RoutesManager) RegisterReportsRoutes(router *gin.Engine) {
 		})
 
 		adminGroup := reportsRouter.Group("")
-		adminGroup.Use(r.middleware.RequireAdmin())
+		adminGroup.Use(r.middleware.RequireAdmin(), r.middleware.RequireScope(auth.ScopeAdmin))
 		{
 			adminGroup.GET("/users", func(c *gin.Context) {
 				rows, err := r.pgClient.Query(c.Request.Context(), "SELECT id, user_id, reporter_id, reason FROM reported_users")
@@ -2,24 +2,39 @@ package routes
 
 import (
 	"instagramplusbackend/auth"
+	"instagramplusbackend/internal/feed"
 	"instagramplusbackend/internal/middleware"
+	"instagramplusbackend/internal/notifications"
+	"instagramplusbackend/internal/realtime"
+	"instagramplusbackend/internal/storage"
+	"instagramplusbackend/internal/timeline"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/redis/go-redis/v9"
 )
 
 type RoutesManager struct {
-	pgClient    *pgxpool.Pool
-	redisClient *redis.Client
-	middleware  *middleware.MiddlewareManager
-	auth        *auth.AuthModule
+	pgClient      *pgxpool.Pool
+	redisClient   *redis.Client
+	middleware    *middleware.MiddlewareManager
+	auth          *auth.AuthModule
+	ranker        *feed.Ranker
+	timeline      *timeline.Service
+	notifications *notifications.Service
+	realtime      *realtime.Hub
+	media         storage.MediaStore
 }
 
-func NewRoutesManager(pgClient *pgxpool.Pool, redisClient *redis.Client, middleware *middleware.MiddlewareManager) *RoutesManager {
+func NewRoutesManager(pgClient *pgxpool.Pool, redisClient *redis.Client, middleware *middleware.MiddlewareManager, authModule *auth.AuthModule, ranker *feed.Ranker, media storage.MediaStore) *RoutesManager {
 	return &RoutesManager{
-		pgClient:    pgClient,
-		redisClient: redisClient,
-		middleware:  middleware,
-		auth:        auth.NewAuthModule(pgClient, redisClient),
+		pgClient:      pgClient,
+		redisClient:   redisClient,
+		middleware:    middleware,
+		auth:          authModule,
+		ranker:        ranker,
+		timeline:      timeline.NewService(pgClient, redisClient),
+		notifications: notifications.NewService(pgClient),
+		realtime:      realtime.NewHub(redisClient),
+		media:         media,
 	}
 }
@@ -29,10 +29,11 @@ func (r *RoutesManager) RegisterSearchRoutes(router *gin.Engine) {
 					FALSE as already_followed
 				FROM users u
 				JOIN user_profiles p ON u.id = p.user_id
-				WHERE u.username ILIKE '%' || $1 || '%'
+				WHERE (u.username ILIKE '%' || $1 || '%'
 				   OR p.name ILIKE '%' || $1 || '%'
-				   OR p.surname ILIKE '%' || $1 || '%'
-				LIMIT 10`, query)
+				   OR p.surname ILIKE '%' || $1 || '%')
+				  AND `+notBlockedSQL("u.id", "$2")+`
+				LIMIT 10`, query, c.GetInt("user_id"))
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
@@ -46,6 +47,7 @@ func (r *RoutesManager) RegisterSearchRoutes(router *gin.Engine) {
 					utils.LogError(c, err)
 					continue
 				}
+				u.ProfileImageRenditions = utils.ImageRenditions(u.ProfileImageURL)
 				users = append(users, u)
 			}
 
@@ -53,15 +55,16 @@ func (r *RoutesManager) RegisterSearchRoutes(router *gin.Engine) {
 			postRows, err := r.pgClient.Query(c.Request.Context(), `
 				SELECT p.id, u.username, p.image_url, p.description, p.creation_timestamp, up.name, up.surname, up.profile_image_url,
 				   (SELECT COUNT(*) FROM posts_likes l WHERE l.post_id = p.id) AS likes_count,
-				   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id) AS comments_count,
+				   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND NOT c.is_deleted) AS comments_count,
 				   FALSE as user_liked
 				FROM posts p
 				JOIN users u ON p.creator_id = u.id
 				JOIN user_profiles up ON up.user_id = u.id
-				WHERE p.description ILIKE '%' || $1 || '%'
-				   OR u.username ILIKE '%' || $1 || '%'
+				WHERE (p.description ILIKE '%' || $1 || '%'
+				   OR u.username ILIKE '%' || $1 || '%')
+				  AND `+visibleToSQL("p.creator_id", "$2")+`
 				ORDER BY p.creation_timestamp DESC
-				LIMIT 10`, query)
+				LIMIT 10`, query, c.GetInt("user_id"))
 			if err != nil {
 				utils.LogError(c, err)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
@@ -77,6 +80,11 @@ func (r *RoutesManager) RegisterSearchRoutes(router *gin.Engine) {
 				}
 				posts = append(posts, p)
 			}
+			if err := r.attachPostDetails(c.Request.Context(), posts); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
 
 			c.JSON(http.StatusOK, models.SearchResponse{
 				Users: users,
@@ -0,0 +1,157 @@
+package routes
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"instagramplusbackend/internal/entities"
+	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/utils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+const (
+	defaultTrendingWindow = 24 * time.Hour
+	maxTrendingWindow     = 7 * 24 * time.Hour
+	defaultTrendingLimit  = 10
+	maxTrendingLimit      = 50
+)
+
+func (r *RoutesManager) RegisterTagsRoutes(router *gin.Engine) {
+	tagsRouter := router.Group("/tags")
+	tagsRouter.Use(r.middleware.RequireAuth())
+	{
+		// Tags used by the most posts over the sliding window, e.g. ?window=6h&limit=20.
+		// Only posts visible to the viewer are counted, private accounts do not leak their tags.
+		tagsRouter.GET("/trending", func(c *gin.Context) {
+			window := defaultTrendingWindow
+			if raw := c.Query("window"); raw != "" {
+				parsed, err := time.ParseDuration(raw)
+				if err != nil || parsed <= 0 || parsed > maxTrendingWindow {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid window"})
+					return
+				}
+				window = parsed
+			}
+
+			limit := defaultTrendingLimit
+			if raw := c.Query("limit"); raw != "" {
+				parsed, err := strconv.Atoi(raw)
+				if err != nil || parsed < 1 || parsed > maxTrendingLimit {
+					c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+					return
+				}
+				limit = parsed
+			}
+
+			rows, err := r.pgClient.Query(c.Request.Context(), `
+				SELECT t.name, COUNT(*) AS posts_count
+				FROM post_tags pt
+				JOIN tags t ON t.id = pt.tag_id
+				JOIN posts p ON p.id = pt.post_id
+				WHERE p.creation_timestamp > NOW() - $1::interval AND `+visibleToSQL("p.creator_id", "$3")+`
+				GROUP BY t.name
+				ORDER BY posts_count DESC, t.name
+				LIMIT $2`, window, limit, c.GetInt("user_id"))
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer rows.Close()
+
+			tags := []models.Tag{}
+			for rows.Next() {
+				var tag models.Tag
+				if err := rows.Scan(&tag.Name, &tag.PostsCount); err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				tags = append(tags, tag)
+			}
+			c.JSON(http.StatusOK, tags)
+		})
+
+		tagsRouter.GET("/:tag", func(c *gin.Context) {
+			tag := models.Tag{Name: entities.NormalizeHashtag(c.Param("tag"))}
+			// Counts only the posts the viewer can see, like the listing below
+			err := r.pgClient.QueryRow(c.Request.Context(), `
+				SELECT COUNT(p.id)
+				FROM tags t
+				LEFT JOIN post_tags pt ON pt.tag_id = t.id
+				LEFT JOIN posts p ON p.id = pt.post_id AND `+visibleToSQL("p.creator_id", "$2")+`
+				WHERE t.name = $1
+				GROUP BY t.id`, tag.Name, c.GetInt("user_id")).Scan(&tag.PostsCount)
+			if err != nil {
+				if err == pgx.ErrNoRows {
+					c.JSON(http.StatusNotFound, gin.H{"error": "tag not found"})
+					return
+				}
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			c.JSON(http.StatusOK, tag)
+		})
+
+		tagsRouter.GET("/:tag/posts", func(c *gin.Context) {
+			page, err := utils.ParsePagination(c)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			cursorTimestamp, cursorID := page.CursorArgs()
+
+			rows, err := r.pgClient.Query(c.Request.Context(), `
+				SELECT p.id, u.username, p.image_url, p.description, p.creation_timestamp, up.name, up.surname, up.profile_image_url,
+				   (SELECT COUNT(*) FROM posts_likes l WHERE l.post_id = p.id) AS likes_count,
+				   (SELECT COUNT(*) FROM comments c WHERE c.post_id = p.id AND NOT c.is_deleted) AS comments_count,
+				   EXISTS (SELECT 1 FROM posts_likes l WHERE l.post_id = p.id AND l.user_id = $1) AS user_liked
+				FROM post_tags pt
+				JOIN tags t ON t.id = pt.tag_id
+				JOIN posts p ON p.id = pt.post_id
+				JOIN users u ON p.creator_id = u.id
+				JOIN user_profiles up ON up.user_id = u.id
+				WHERE t.name = $2 AND `+visibleToSQL("p.creator_id", "$1")+`
+				  AND ($3 IS NULL OR (p.creation_timestamp, p.id) < ($3, $4))
+				ORDER BY p.creation_timestamp DESC, p.id DESC
+				LIMIT $5`, c.GetInt("user_id"), entities.NormalizeHashtag(c.Param("tag")), cursorTimestamp, cursorID, page.FetchLimit())
+			if err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			defer rows.Close()
+
+			posts := []models.Post{}
+			for rows.Next() {
+				var post models.Post
+				err := rows.Scan(&post.ID, &post.AuthorUsername, &post.ImageURL, &post.Description, &post.CreationTimestamp, &post.AuthorName, &post.AuthorSurname, &post.AuthorProfileImageURL, &post.LikesCount, &post.CommentsCount, &post.AlreadyLiked)
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+				posts = append(posts, post)
+			}
+			if err := rows.Err(); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+
+			resp := utils.Paginate(posts, page, models.Post.CursorKey)
+			if err := r.attachPostDetails(c.Request.Context(), resp.Items); err != nil {
+				utils.LogError(c, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+				return
+			}
+			c.JSON(http.StatusOK, resp)
+		})
+	}
+}
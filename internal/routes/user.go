@@ -1,9 +1,12 @@
 package routes
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
+	"instagramplusbackend/auth"
+	"instagramplusbackend/internal/oidc"
 	"instagramplusbackend/internal/utils"
 
 	"github.com/gin-gonic/gin"
@@ -12,7 +15,7 @@ import (
 
 func (r *RoutesManager) RegisterAccountRoutes(router *gin.Engine) {
 	accountRouter := router.Group("/account")
-	accountRouter.Use(r.middleware.RequireAuth())
+	accountRouter.Use(r.middleware.RequireAuth(), r.middleware.RequireSession())
 	{
 		accountRouter.DELETE("/remove/:user_id", r.middleware.RequireUserOwnership("user_id"), func(c *gin.Context) {
 			userID := c.Param("user_id")
@@ -25,7 +28,7 @@ func (r *RoutesManager) RegisterAccountRoutes(router *gin.Engine) {
 				return
 			}
 			if profileImage != "" {
-				_ = utils.RemoveProfileImage(profileImage)
+				_ = utils.RemoveProfileImage(c.Request.Context(), r.media, profileImage)
 			}
 
 			// Delete user from users table (CASCADE should handle related data)
@@ -36,6 +39,11 @@ func (r *RoutesManager) RegisterAccountRoutes(router *gin.Engine) {
 				return
 			}
 
+			userIDInt, _ := strconv.Atoi(userID)
+			if err := r.auth.RevokeAllSessions(c.Request.Context(), userIDInt); err != nil {
+				utils.LogError(c, err)
+			}
+
 			c.JSON(http.StatusOK, gin.H{"message": "Account removed successfully"})
 		})
 
@@ -51,7 +59,7 @@ func (r *RoutesManager) RegisterAccountRoutes(router *gin.Engine) {
 				return
 			}
 			userIDInt, _ := strconv.Atoi(userID)
-			err := r.auth.ChangePassword(c.Request.Context(), userIDInt, req.OldPassword, req.NewPassword)
+			err := r.auth.ChangePassword(c.Request.Context(), userIDInt, req.OldPassword, req.NewPassword, c.GetString("session_id"))
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
@@ -76,7 +84,7 @@ func (r *RoutesManager) RegisterAccountRoutes(router *gin.Engine) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 				return
 			}
-			c.JSON(http.StatusOK, gin.H{"message": "Email changed successfully"})
+			c.JSON(http.StatusOK, gin.H{"message": "Verification email sent to the new address"})
 		})
 
 		// Set premium status endpoint
@@ -102,5 +110,55 @@ func (r *RoutesManager) RegisterAccountRoutes(router *gin.Engine) {
 			}
 			c.JSON(http.StatusOK, gin.H{"message": "Premium disabled"})
 		})
+
+		identitiesRouter := accountRouter.Group("/identities")
+		{
+			identitiesRouter.GET("", func(c *gin.Context) {
+				identities, err := r.auth.ListIdentities(c.Request.Context(), c.GetInt("user_id"))
+				if err != nil {
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				c.JSON(http.StatusOK, identities)
+			})
+
+			// Opened by the browser from the settings page, the provider redirects back to /auth/oidc/:provider/callback
+			identitiesRouter.GET("/:provider/link", func(c *gin.Context) {
+				target, state, err := r.auth.BeginOIDC(c.Request.Context(), c.Param("provider"), c.GetInt("user_id"))
+				if err != nil {
+					if errors.Is(err, oidc.ErrUnknownProvider) {
+						c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusBadGateway, gin.H{"error": "identity provider unavailable"})
+					return
+				}
+
+				setOIDCStateCookie(c, state)
+				c.Redirect(http.StatusFound, target)
+			})
+
+			identitiesRouter.DELETE("/:provider", func(c *gin.Context) {
+				err := r.auth.UnlinkIdentity(c.Request.Context(), c.GetInt("user_id"), c.Param("provider"))
+				if err != nil {
+					if errors.Is(err, auth.ErrIdentityNotFound) {
+						c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+						return
+					}
+					if errors.Is(err, auth.ErrLastLoginMethod) {
+						c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+						return
+					}
+					utils.LogError(c, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "database error"})
+					return
+				}
+
+				c.JSON(http.StatusOK, gin.H{})
+			})
+		}
 	}
 }
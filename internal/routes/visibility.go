@@ -0,0 +1,76 @@
+package routes
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// notBlockedSQL is a SQL condition that holds when neither the viewer nor the user in userColumn blocked the other
+func notBlockedSQL(userColumn, viewerParam string) string {
+	return `NOT EXISTS (SELECT 1 FROM blocks vis_b
+		WHERE (vis_b.blocker_id = ` + userColumn + ` AND vis_b.blocked_id = ` + viewerParam + `)
+		   OR (vis_b.blocker_id = ` + viewerParam + ` AND vis_b.blocked_id = ` + userColumn + `))`
+}
+
+// notMutedSQL is a SQL condition that holds when the viewer did not mute the user in userColumn.
+// Mutes only hide content from the viewer's own feeds and listings, they are never enforced towards the muted user.
+func notMutedSQL(userColumn, viewerParam string) string {
+	return `NOT EXISTS (SELECT 1 FROM mutes vis_m WHERE vis_m.muter_id = ` + viewerParam + ` AND vis_m.muted_id = ` + userColumn + `)`
+}
+
+// visibleToSQL is a SQL condition that holds when the viewer may see the content of the user in userColumn.
+// Content of private accounts is visible only to the owner and approved followers, blocks hide content both ways.
+func visibleToSQL(userColumn, viewerParam string) string {
+	return `(` + notBlockedSQL(userColumn, viewerParam) + ` AND (` + userColumn + ` = ` + viewerParam + `
+		OR NOT EXISTS (SELECT 1 FROM user_profiles vis_up WHERE vis_up.user_id = ` + userColumn + ` AND vis_up.is_private)
+		OR EXISTS (SELECT 1 FROM follows vis_f WHERE vis_f.profile_id = ` + userColumn + ` AND vis_f.follower_id = ` + viewerParam + `)))`
+}
+
+// isBlocked reports whether either user blocked the other
+func (r *RoutesManager) isBlocked(ctx context.Context, userID, otherID int) (bool, error) {
+	var notBlocked bool
+	err := r.pgClient.QueryRow(ctx, `SELECT `+notBlockedSQL("$1::int", "$2::int"), userID, otherID).Scan(&notBlocked)
+	return !notBlocked, err
+}
+
+// isMuted reports whether the viewer muted the user
+func (r *RoutesManager) isMuted(ctx context.Context, viewerID, userID int) (bool, error) {
+	var notMuted bool
+	err := r.pgClient.QueryRow(ctx, `SELECT `+notMutedSQL("$1::int", "$2::int"), userID, viewerID).Scan(&notMuted)
+	return !notMuted, err
+}
+
+// canViewUser reports whether the viewer may see the posts, comments and follow lists of the owner
+func (r *RoutesManager) canViewUser(ctx context.Context, viewerID, ownerID int) (bool, error) {
+	var visible bool
+	err := r.pgClient.QueryRow(ctx, `SELECT `+visibleToSQL("$1::int", "$2::int"), ownerID, viewerID).Scan(&visible)
+	return visible, err
+}
+
+// canViewPost reports whether the post exists and its author's content is visible to the viewer
+func (r *RoutesManager) canViewPost(ctx context.Context, viewerID, postID int) (bool, error) {
+	var visible bool
+	err := r.pgClient.QueryRow(ctx, `
+		SELECT `+visibleToSQL("p.creator_id", "$2")+`
+		FROM posts p
+		WHERE p.id = $1`, postID, viewerID).Scan(&visible)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return visible, err
+}
+
+// canViewComment reports whether the comment exists, belongs to a post visible to the viewer and its author is not blocked
+func (r *RoutesManager) canViewComment(ctx context.Context, viewerID, commentID int) (bool, error) {
+	var visible bool
+	err := r.pgClient.QueryRow(ctx, `
+		SELECT `+visibleToSQL("p.creator_id", "$2")+` AND `+notBlockedSQL("c.author_id", "$2")+`
+		FROM comments c
+		JOIN posts p ON p.id = c.post_id
+		WHERE c.id = $1`, commentID, viewerID).Scan(&visible)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	}
+	return visible, err
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+var ErrNotFound = errors.New("media not found")
+
+var ErrInvalidKey = errors.New("invalid media key")
+
+// MediaStore stores uploaded media under slash separated keys such as "posts/<name>.jpg"
+type MediaStore interface {
+	Put(ctx context.Context, key string, r io.Reader, contentType string) error
+	Delete(ctx context.Context, key string) error
+	// URL returns the public URL clients use to fetch the media
+	URL(key string) string
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// NewFromEnv builds the store selected by MEDIA_STORE, "local" (default) or "s3"
+func NewFromEnv() (MediaStore, error) {
+	switch os.Getenv("MEDIA_STORE") {
+	case "", "local":
+		return NewLocalStore(
+			envOr("MEDIA_ROOT", "c://nginx/data"),
+			envOr("MEDIA_BASE_URL", "/images"),
+		), nil
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          envOr("S3_REGION", "us-east-1"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+		})
+	default:
+		return nil, errors.New("unknown MEDIA_STORE: " + os.Getenv("MEDIA_STORE"))
+	}
+}
+
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
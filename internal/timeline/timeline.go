@@ -0,0 +1,238 @@
+package timeline
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// maxLength is the number of post IDs kept per timeline, older pages are served from Postgres
+	maxLength = 800
+	// timelineTTL drops timelines of inactive users, they are rebuilt on the next read
+	timelineTTL = 7 * 24 * time.Hour
+	// builtMarker is a member with +inf score marking a timeline as built, even when it has no posts
+	builtMarker = "0"
+)
+
+// addScript adds the score and member pairs of ARGV[3..] to the timeline in KEYS[1] and trims it to ARGV[1] posts.
+// Timelines without the built marker ARGV[2] are left alone, they expired or were never built and
+// adding to them would create a partial timeline that looks built.
+var addScript = redis.NewScript(`
+if not redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	return 0
+end
+for i = 3, #ARGV, 2 do
+	redis.call('ZADD', KEYS[1], ARGV[i], ARGV[i + 1])
+end
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, -(tonumber(ARGV[1]) + 2))
+return 1
+`)
+
+func addArgs(members ...redis.Z) []any {
+	args := []any{maxLength, builtMarker}
+	for _, member := range members {
+		args = append(args, strconv.FormatFloat(member.Score, 'f', -1, 64), member.Member)
+	}
+	return args
+}
+
+// Service keeps per-follower timelines of post IDs in Redis sorted sets scored by creation time
+type Service struct {
+	db    *pgxpool.Pool
+	redis *redis.Client
+}
+
+func NewService(db *pgxpool.Pool, redis *redis.Client) *Service {
+	return &Service{
+		db:    db,
+		redis: redis,
+	}
+}
+
+func timelineKey(userID int) string {
+	return "timeline:" + strconv.Itoa(userID)
+}
+
+func score(createdAt time.Time) float64 {
+	return float64(createdAt.UnixMicro())
+}
+
+func (s *Service) followerIDs(ctx context.Context, profileID int) ([]int, error) {
+	rows, err := s.db.Query(ctx, `SELECT follower_id FROM follows WHERE profile_id = $1`, profileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	followerIDs := []int{}
+	for rows.Next() {
+		var followerID int
+		if err := rows.Scan(&followerID); err != nil {
+			return nil, err
+		}
+		followerIDs = append(followerIDs, followerID)
+	}
+	return followerIDs, rows.Err()
+}
+
+// PushPost fans a new post out to the timelines of the author's followers
+func (s *Service) PushPost(ctx context.Context, authorID, postID int, createdAt time.Time) error {
+	followerIDs, err := s.followerIDs(ctx, authorID)
+	if err != nil || len(followerIDs) == 0 {
+		return err
+	}
+
+	// Only timelines that are already built are updated, the others are built from Postgres on read
+	pipe := s.redis.Pipeline()
+	args := addArgs(redis.Z{Score: score(createdAt), Member: strconv.Itoa(postID)})
+	for _, followerID := range followerIDs {
+		addScript.Eval(ctx, pipe, []string{timelineKey(followerID)}, args...)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// RemovePost removes a deleted post from the timelines of the author's followers
+func (s *Service) RemovePost(ctx context.Context, authorID, postID int) error {
+	followerIDs, err := s.followerIDs(ctx, authorID)
+	if err != nil || len(followerIDs) == 0 {
+		return err
+	}
+
+	pipe := s.redis.Pipeline()
+	for _, followerID := range followerIDs {
+		pipe.ZRem(ctx, timelineKey(followerID), strconv.Itoa(postID))
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Follow backfills the follower's timeline with the recent posts of the followed profile
+func (s *Service) Follow(ctx context.Context, followerID, profileID int) error {
+	key := timelineKey(followerID)
+	exists, err := s.redis.Exists(ctx, key).Result()
+	if err != nil || exists == 0 {
+		return err
+	}
+
+	members, err := s.postsOf(ctx, `
+		SELECT id, creation_timestamp FROM posts
+		WHERE creator_id = $1
+		ORDER BY creation_timestamp DESC, id DESC
+		LIMIT $2`, profileID, maxLength)
+	if err != nil || len(members) == 0 {
+		return err
+	}
+
+	return addScript.Run(ctx, s.redis, []string{key}, addArgs(members...)...).Err()
+}
+
+// Unfollow removes the posts of the unfollowed profile from the follower's timeline
+func (s *Service) Unfollow(ctx context.Context, followerID, profileID int) error {
+	key := timelineKey(followerID)
+	exists, err := s.redis.Exists(ctx, key).Result()
+	if err != nil || exists == 0 {
+		return err
+	}
+
+	members, err := s.postsOf(ctx, `SELECT id, creation_timestamp FROM posts WHERE creator_id = $1`, profileID)
+	if err != nil || len(members) == 0 {
+		return err
+	}
+
+	postIDs := make([]any, len(members))
+	for i, member := range members {
+		postIDs[i] = member.Member
+	}
+	return s.redis.ZRem(ctx, key, postIDs...).Err()
+}
+
+// Rebuild replaces the user's timeline with the newest posts of the profiles they follow
+func (s *Service) Rebuild(ctx context.Context, userID int) error {
+	members, err := s.postsOf(ctx, `
+		SELECT p.id, p.creation_timestamp
+		FROM posts p
+		JOIN follows f ON f.profile_id = p.creator_id
+		WHERE f.follower_id = $1
+		ORDER BY p.creation_timestamp DESC, p.id DESC
+		LIMIT $2`, userID, maxLength)
+	if err != nil {
+		return err
+	}
+	members = append(members, redis.Z{Score: math.Inf(1), Member: builtMarker})
+
+	key := timelineKey(userID)
+	pipe := s.redis.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.ZAdd(ctx, key, members...)
+	pipe.Expire(ctx, key, timelineTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// PostIDs returns up to count post IDs created at or before the given time, newest first.
+// complete is false when the page reaches past the trimmed end of the timeline and has to be served from Postgres.
+func (s *Service) PostIDs(ctx context.Context, userID int, before *time.Time, count int) (postIDs []int, complete bool, err error) {
+	key := timelineKey(userID)
+	exists, err := s.redis.Exists(ctx, key).Result()
+	if err != nil {
+		return nil, false, err
+	}
+	if exists == 0 {
+		if err := s.Rebuild(ctx, userID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	maxScore := "+inf"
+	if before != nil {
+		maxScore = strconv.FormatFloat(score(*before), 'f', -1, 64)
+	}
+
+	pipe := s.redis.Pipeline()
+	rangeCmd := pipe.ZRevRangeByScore(ctx, key, &redis.ZRangeBy{Max: maxScore, Min: "-inf", Count: int64(count)})
+	cardCmd := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, timelineTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, false, err
+	}
+
+	for _, member := range rangeCmd.Val() {
+		if member == builtMarker {
+			continue
+		}
+		postID, err := strconv.Atoi(member)
+		if err != nil {
+			return nil, false, err
+		}
+		postIDs = append(postIDs, postID)
+	}
+
+	trimmed := cardCmd.Val() > maxLength
+	complete = len(postIDs) >= count || !trimmed
+	return postIDs, complete, nil
+}
+
+func (s *Service) postsOf(ctx context.Context, query string, args ...any) ([]redis.Z, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []redis.Z{}
+	for rows.Next() {
+		var postID int
+		var createdAt time.Time
+		if err := rows.Scan(&postID, &createdAt); err != nil {
+			return nil, err
+		}
+		members = append(members, redis.Z{Score: score(createdAt), Member: strconv.Itoa(postID)})
+	}
+	return members, rows.Err()
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"instagramplusbackend/internal/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	DefaultPageLimit = 20
+	MaxPageLimit     = 100
+)
+
+var ErrInvalidPagination = errors.New("invalid pagination parameters")
+
+// Cursor points at the last row of a page, ordered by (creation_timestamp, id)
+type Cursor struct {
+	Timestamp time.Time
+	ID        int
+}
+
+// Pagination holds the page size and the optional cursor parsed from a request
+type Pagination struct {
+	Limit  int
+	Cursor *Cursor
+}
+
+// ParsePagination reads the "limit" and "cursor" query parameters
+func ParsePagination(c *gin.Context) (Pagination, error) {
+	limit, err := ParseLimit(c)
+	p := Pagination{Limit: limit}
+	if err != nil {
+		return p, err
+	}
+
+	if cursorStr := c.Query("cursor"); cursorStr != "" {
+		cursor, err := DecodeCursor(cursorStr)
+		if err != nil {
+			return p, ErrInvalidPagination
+		}
+		p.Cursor = &cursor
+	}
+
+	return p, nil
+}
+
+// ParseLimit reads the "limit" query parameter, for lists with their own kind of cursor
+func ParseLimit(c *gin.Context) (int, error) {
+	limitStr := c.Query("limit")
+	if limitStr == "" {
+		return DefaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		return DefaultPageLimit, ErrInvalidPagination
+	}
+	return min(limit, MaxPageLimit), nil
+}
+
+// CursorArgs returns the cursor values as query arguments, the timestamp is nil on the first page
+func (p Pagination) CursorArgs() (*time.Time, int) {
+	if p.Cursor == nil {
+		return nil, 0
+	}
+	return &p.Cursor.Timestamp, p.Cursor.ID
+}
+
+// FetchLimit is the number of rows to query, one more than the page size to detect a next page
+func (p Pagination) FetchLimit() int {
+	return p.Limit + 1
+}
+
+func EncodeCursor(timestamp time.Time, id int) string {
+	raw := strconv.FormatInt(timestamp.UnixNano(), 10) + ":" + strconv.Itoa(id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func DecodeCursor(cursor string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	tsStr, idStr, found := strings.Cut(string(raw), ":")
+	if !found {
+		return Cursor{}, ErrInvalidPagination
+	}
+	ts, err := strconv.ParseInt(tsStr, 10, 64)
+	if err != nil {
+		return Cursor{}, err
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return Cursor{}, err
+	}
+
+	return Cursor{Timestamp: time.Unix(0, ts).UTC(), ID: id}, nil
+}
+
+// Paginate trims the extra row fetched by FetchLimit and builds the response envelope
+func Paginate[T any](items []T, p Pagination, key func(T) (time.Time, int)) models.PaginatedResponse[T] {
+	resp := models.PaginatedResponse[T]{Items: items}
+	if len(items) > p.Limit {
+		resp.Items = items[:p.Limit]
+		ts, id := key(resp.Items[p.Limit-1])
+		resp.NextCursor = EncodeCursor(ts, id)
+	}
+	return resp
+}
@@ -1,99 +1,189 @@
 package utils
 
 import (
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"os"
-	"path/filepath"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"instagramplusbackend/internal/imaging"
+	"instagramplusbackend/internal/models"
+	"instagramplusbackend/internal/storage"
 
 	"github.com/gin-gonic/gin"
 )
 
-var nginxPath = "c://nginx/"
+var postImageKeyPrefix = "posts/"
+var profileImageKeyPrefix = "profiles/"
 
-var postImagePathPrefix = "/images/posts/"
-var profileImagePathPrefix = "/images/profiles/"
+// MaxPostImages is the maximum number of media items in a carousel post
+const MaxPostImages = 10
 
-func UploadPostImage(c *gin.Context) (string, error) {
-	if err := c.Request.ParseMultipartForm(10 << 20); err != nil { // Limit to 10MB
-		return "", err
+var ErrTooManyImages = errors.New("too many images")
+
+type UploadedImage struct {
+	URL    string
+	Width  int
+	Height int
+}
+
+// UploadPostImages stores every file of the "images" form field in order, falling back to a single "image".
+// Either all files are stored or none, already stored files are removed when a later one fails.
+func UploadPostImages(c *gin.Context, store storage.MediaStore) ([]UploadedImage, error) {
+	if err := c.Request.ParseMultipartForm(10 << 20); err != nil { // Keep up to 10MB in memory
+		return nil, err
 	}
 
-	file, err := c.FormFile("image")
+	files := c.Request.MultipartForm.File["images"]
+	if len(files) == 0 {
+		files = c.Request.MultipartForm.File["image"]
+	}
+	if len(files) == 0 {
+		return nil, http.ErrMissingFile
+	}
+	if len(files) > MaxPostImages {
+		return nil, ErrTooManyImages
+	}
+
+	images := []UploadedImage{}
+	for _, file := range files {
+		uploaded, err := saveImage(c.Request.Context(), store, postImageKeyPrefix, file)
+		if err != nil {
+			RemovePostImages(c.Request.Context(), store, images)
+			return nil, err
+		}
+		images = append(images, uploaded)
+	}
+
+	return images, nil
+}
+
+// saveImage runs the upload through the processing pipeline and stores every rendition
+func saveImage(ctx context.Context, store storage.MediaStore, keyPrefix string, file *multipart.FileHeader) (UploadedImage, error) {
+	src, err := file.Open()
 	if err != nil {
-		return "", err
+		return UploadedImage{}, err
 	}
+	defer src.Close()
 
-	uploadPath := filepath.Join(nginxPath, "data/posts")
-	if err := os.MkdirAll(uploadPath, os.ModePerm); err != nil {
-		return "", err
+	outputs, err := imaging.Process(src, imaging.DefaultRenditions)
+	if err != nil {
+		return UploadedImage{}, err
 	}
 
 	randomBytes := make([]byte, 16)
 	if _, err = rand.Read(randomBytes); err != nil {
-		return "", err
+		return UploadedImage{}, err
+	}
+	name := hex.EncodeToString(randomBytes)
+
+	uploaded := UploadedImage{}
+	stored := []string{}
+	for _, output := range outputs {
+		key := keyPrefix + renditionFileName(name, output.Rendition.Name)
+		if err := store.Put(ctx, key, bytes.NewReader(output.Data), "image/jpeg"); err != nil {
+			for _, storedKey := range stored {
+				store.Delete(ctx, storedKey)
+			}
+			return UploadedImage{}, err
+		}
+		stored = append(stored, key)
+
+		if output.Rendition == imaging.Full {
+			uploaded = UploadedImage{URL: store.URL(key), Width: output.Width, Height: output.Height}
+		}
 	}
 
-	fileName := hex.EncodeToString(randomBytes) + filepath.Ext(file.Filename)
+	return uploaded, nil
+}
 
-	dst := filepath.Join(uploadPath, fileName)
-	if err := c.SaveUploadedFile(file, dst); err != nil {
-		return "", err
-	}
+func renditionFileName(name, rendition string) string {
+	return name + "." + rendition + ".jpg"
+}
 
-	imageURL := postImagePathPrefix + fileName
+// renditionFileNames lists the stored files of an image, images uploaded before the pipeline have a single file
+func renditionFileNames(imageURL string) []string {
+	fileName := path.Base(imageURL)
+	name, found := strings.CutSuffix(fileName, "."+imaging.Full.Name+".jpg")
+	if !found {
+		return []string{fileName}
+	}
 
-	return imageURL, nil
+	fileNames := []string{}
+	for _, rendition := range imaging.DefaultRenditions {
+		fileNames = append(fileNames, renditionFileName(name, rendition.Name))
+	}
+	return fileNames
 }
 
-func RemovePostImage(imagePath string) error {
-	uploadPath := filepath.Join(nginxPath, "data/posts", filepath.Base(imagePath))
+// ImageRenditions derives the rendition URLs from the URL of the full image
+func ImageRenditions(imageURL string) models.ImageRenditions {
+	if imageURL == "" {
+		return models.ImageRenditions{}
+	}
 
-	if err := os.Remove(uploadPath); err != nil {
-		return err
+	base := strings.TrimSuffix(imageURL, path.Base(imageURL))
+	name, found := strings.CutSuffix(path.Base(imageURL), "."+imaging.Full.Name+".jpg")
+	if !found {
+		return models.ImageRenditions{ThumbnailURL: imageURL, FeedURL: imageURL, FullURL: imageURL}
 	}
 
-	return nil
+	return models.ImageRenditions{
+		ThumbnailURL: base + renditionFileName(name, imaging.Thumbnail.Name),
+		FeedURL:      base + renditionFileName(name, imaging.Feed.Name),
+		FullURL:      imageURL,
+	}
 }
 
-func UploadProfileImage(c *gin.Context) (string, error) {
-	if err := c.Request.ParseMultipartForm(10 << 20); err != nil { // Limit to 10MB
-		return "", err
-	}
+func RemovePostImage(ctx context.Context, store storage.MediaStore, imageURL string) error {
+	return removeImage(ctx, store, postImageKeyPrefix, imageURL)
+}
 
-	file, err := c.FormFile("image")
-	if err != nil {
-		return "", err
+// RemovePostImages removes every given image and returns the first error
+func RemovePostImages(ctx context.Context, store storage.MediaStore, images []UploadedImage) error {
+	var firstErr error
+	for _, uploaded := range images {
+		if err := RemovePostImage(ctx, store, uploaded.URL); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
 
-	uploadPath := filepath.Join(nginxPath, "data/profiles")
-	if err := os.MkdirAll(uploadPath, os.ModePerm); err != nil {
+func UploadProfileImage(c *gin.Context, store storage.MediaStore) (string, error) {
+	if err := c.Request.ParseMultipartForm(10 << 20); err != nil { // Limit to 10MB
 		return "", err
 	}
 
-	randomBytes := make([]byte, 16)
-	if _, err = rand.Read(randomBytes); err != nil {
+	file, err := c.FormFile("image")
+	if err != nil {
 		return "", err
 	}
 
-	fileName := hex.EncodeToString(randomBytes) + filepath.Ext(file.Filename)
-
-	dst := filepath.Join(uploadPath, fileName)
-	if err := c.SaveUploadedFile(file, dst); err != nil {
+	uploaded, err := saveImage(c.Request.Context(), store, profileImageKeyPrefix, file)
+	if err != nil {
 		return "", err
 	}
 
-	imageURL := profileImagePathPrefix + fileName
-
-	return imageURL, nil
+	return uploaded.URL, nil
 }
 
-func RemoveProfileImage(imagePath string) error {
-	uploadPath := filepath.Join(nginxPath, "data/profiles", filepath.Base(imagePath))
+func RemoveProfileImage(ctx context.Context, store storage.MediaStore, imagePath string) error {
+	return removeImage(ctx, store, profileImageKeyPrefix, imagePath)
+}
 
-	if err := os.Remove(uploadPath); err != nil {
-		return err
+// removeImage deletes every rendition of the image, the first error is returned
+func removeImage(ctx context.Context, store storage.MediaStore, keyPrefix, imageURL string) error {
+	var firstErr error
+	for _, fileName := range renditionFileNames(imageURL) {
+		if err := store.Delete(ctx, keyPrefix+fileName); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
-
-	return nil
+	return firstErr
 }
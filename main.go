@@ -2,8 +2,13 @@ package main
 
 import (
 	"context"
+	"instagramplusbackend/auth"
+	"instagramplusbackend/internal/feed"
+	"instagramplusbackend/internal/mailer"
 	"instagramplusbackend/internal/middleware"
+	"instagramplusbackend/internal/oidc"
 	"instagramplusbackend/internal/routes"
+	"instagramplusbackend/internal/storage"
 	"net/http"
 	"os"
 	"strconv"
@@ -38,10 +43,35 @@ func main() {
 	})
 	defer redisClient.Close()
 
+	mediaStore, err := storage.NewFromEnv()
+	if err != nil {
+		panic("failed to configure media storage: " + err.Error())
+	}
+
+	mailSender, err := mailer.NewFromEnv()
+	if err != nil {
+		panic("failed to configure mailer: " + err.Error())
+	}
+
+	oidcProviders, err := oidc.ProvidersFromEnv()
+	if err != nil {
+		panic("failed to configure OIDC providers: " + err.Error())
+	}
+
+	ranker, err := feed.NewRankerFromEnv()
+	if err != nil {
+		panic("failed to configure feed ranking: " + err.Error())
+	}
+
+	authModule, err := auth.NewAuthModule(pgClient, redisClient, mailSender, oidcProviders)
+	if err != nil {
+		panic("failed to configure auth: " + err.Error())
+	}
+
 	r := gin.Default()
 	r.RedirectTrailingSlash = false
 
-	middlewareManager := middleware.NewMiddlewareManager(pgClient, redisClient)
+	middlewareManager := middleware.NewMiddlewareManager(pgClient, redisClient, authModule)
 	r.Use(middlewareManager.CORS())
 
 	r.GET("/", func(c *gin.Context) {
@@ -50,7 +80,7 @@ func main() {
 		})
 	})
 
-	routesManager := routes.NewRoutesManager(pgClient, redisClient, middlewareManager)
+	routesManager := routes.NewRoutesManager(pgClient, redisClient, middlewareManager, authModule, ranker, mediaStore)
 	routesManager.RegisterAuthRoutes(r)
 	routesManager.RegisterPostsRoutes(r)
 	routesManager.RegisterUserRoutes(r)
@@ -58,6 +88,10 @@ func main() {
 	routesManager.RegisterSearchRoutes(r)
 	routesManager.RegisterReportsRoutes(r)
 	routesManager.RegisterAccountRoutes(r)
+	routesManager.RegisterTagsRoutes(r)
+	routesManager.RegisterNotificationsRoutes(r)
+	routesManager.RegisterEventsRoutes(r)
+	routesManager.RegisterGatewayRoutes(r)
 
 	r.Run(":5069")
 }
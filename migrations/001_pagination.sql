@@ -0,0 +1,11 @@
+-- Migrations apply in order on top of the baseline schema, e.g. with
+--   for f in migrations/*.sql; do psql "$DB_URL" -v ON_ERROR_STOP=1 -f "$f"; done
+-- Every statement is idempotent, so re-running a file is safe.
+
+-- Follower listings are ordered by when the follow was made, existing follows get the migration time
+ALTER TABLE follows ADD COLUMN IF NOT EXISTS creation_timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS follows_profile_created_idx ON follows (profile_id, creation_timestamp DESC, follower_id DESC);
+CREATE INDEX IF NOT EXISTS follows_follower_created_idx ON follows (follower_id, creation_timestamp DESC, profile_id DESC);
+CREATE INDEX IF NOT EXISTS posts_creator_created_idx ON posts (creator_id, creation_timestamp DESC, id DESC);
+CREATE INDEX IF NOT EXISTS comments_post_created_idx ON comments (post_id, creation_timestamp, id);
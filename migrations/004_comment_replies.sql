@@ -0,0 +1,5 @@
+-- Replies point at the top-level comment they answer, a removed comment with replies stays as a placeholder
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS parent_id INTEGER REFERENCES comments (id) ON DELETE CASCADE;
+ALTER TABLE comments ADD COLUMN IF NOT EXISTS is_deleted BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS comments_parent_created_idx ON comments (parent_id, creation_timestamp, id) WHERE parent_id IS NOT NULL;
@@ -0,0 +1,7 @@
+-- The primary key rejects a second like of the same comment, likes go with the comment or the user
+CREATE TABLE IF NOT EXISTS comments_likes (
+    comment_id INTEGER NOT NULL REFERENCES comments (id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    creation_timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (comment_id, user_id)
+);
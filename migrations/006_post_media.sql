@@ -0,0 +1,10 @@
+-- The ordered images of a carousel post, posts.image_url keeps the cover for older clients
+CREATE TABLE IF NOT EXISTS post_media (
+    post_id INTEGER NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    url TEXT NOT NULL,
+    width INTEGER NOT NULL,
+    height INTEGER NOT NULL,
+    alt_text TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (post_id, position)
+);
@@ -0,0 +1,11 @@
+-- Private accounts only show their posts and follower lists to followers, follows need the owner's approval
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS is_private BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS follow_requests (
+    profile_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    requester_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    creation_timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (profile_id, requester_id)
+);
+
+CREATE INDEX IF NOT EXISTS follow_requests_profile_created_idx ON follow_requests (profile_id, creation_timestamp DESC, requester_id DESC);
@@ -0,0 +1,11 @@
+-- Blocks hide users from each other in both directions, the reverse index serves the "blocked by" side of the checks
+CREATE TABLE IF NOT EXISTS blocks (
+    blocker_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    blocked_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    creation_timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (blocker_id, blocked_id),
+    CHECK (blocker_id <> blocked_id)
+);
+
+CREATE INDEX IF NOT EXISTS blocks_blocked_idx ON blocks (blocked_id, blocker_id);
+CREATE INDEX IF NOT EXISTS blocks_blocker_created_idx ON blocks (blocker_id, creation_timestamp DESC, blocked_id DESC);
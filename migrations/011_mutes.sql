@@ -0,0 +1,10 @@
+-- Mutes are one-sided and silent, only the muter's feeds and comment listings change
+CREATE TABLE IF NOT EXISTS mutes (
+    muter_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    muted_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    creation_timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (muter_id, muted_id),
+    CHECK (muter_id <> muted_id)
+);
+
+CREATE INDEX IF NOT EXISTS mutes_muter_created_idx ON mutes (muter_id, creation_timestamp DESC, muted_id DESC);
@@ -0,0 +1,5 @@
+-- Existing accounts start unverified and can ask for a new link, a changed email waits in pending_email until confirmed
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email TEXT;
+
+CREATE INDEX IF NOT EXISTS users_verified_email_idx ON users (email) WHERE email_verified;
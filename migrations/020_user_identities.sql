@@ -0,0 +1,11 @@
+-- A provider account links to at most one user and a user links at most one account per provider.
+-- Accounts created through a provider have an empty password hash, which never matches.
+CREATE TABLE IF NOT EXISTS user_identities (
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email TEXT NOT NULL DEFAULT '',
+    creation_timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (provider, subject),
+    UNIQUE (user_id, provider)
+);
@@ -0,0 +1,14 @@
+-- A mention belongs to either a post description or a comment, offsets and lengths count code points of that text
+CREATE TABLE IF NOT EXISTS mentions (
+    id SERIAL PRIMARY KEY,
+    post_id INTEGER REFERENCES posts (id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments (id) ON DELETE CASCADE,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    start_offset INTEGER NOT NULL,
+    length INTEGER NOT NULL,
+    CHECK ((post_id IS NULL) <> (comment_id IS NULL))
+);
+
+CREATE INDEX IF NOT EXISTS mentions_post_idx ON mentions (post_id) WHERE post_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS mentions_comment_idx ON mentions (comment_id) WHERE comment_id IS NOT NULL;
+CREATE INDEX IF NOT EXISTS mentions_user_idx ON mentions (user_id);
@@ -0,0 +1,25 @@
+-- A notification groups the events of one type on one target, notification_actors holds who took part
+CREATE TABLE IF NOT EXISTS notifications (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    type TEXT NOT NULL,
+    post_id INTEGER REFERENCES posts (id) ON DELETE CASCADE,
+    comment_id INTEGER REFERENCES comments (id) ON DELETE CASCADE,
+    is_read BOOLEAN NOT NULL DEFAULT FALSE,
+    creation_timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS notifications_user_created_idx ON notifications (user_id, creation_timestamp DESC, id DESC);
+
+-- Notify joins concurrent events into the unread group of their target through this index
+CREATE UNIQUE INDEX IF NOT EXISTS notifications_unread_group ON notifications
+    (user_id, type, COALESCE(post_id, 0), COALESCE(comment_id, 0)) WHERE NOT is_read;
+
+CREATE TABLE IF NOT EXISTS notification_actors (
+    notification_id INTEGER NOT NULL REFERENCES notifications (id) ON DELETE CASCADE,
+    actor_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    creation_timestamp TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (notification_id, actor_id)
+);
+
+CREATE INDEX IF NOT EXISTS notification_actors_actor_idx ON notification_actors (actor_id);